@@ -0,0 +1,206 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+
+	"github.com/wish/gatekeeper/verifier"
+)
+
+// DefaultPathTemplate is the path resources are verified at, so that PATH(1) is the namespace like in a
+// repository with a folder per namespace
+const DefaultPathTemplate = "{cluster}/{namespace}/{name}"
+
+// ClusterScope is the namespace of cluster-scoped resources in paths
+const ClusterScope = "_cluster"
+
+// listLimit is the number of resources listed per request
+const listLimit = 500
+
+// Auditor lists the resources of a cluster and verifies them against a ruleset
+type Auditor struct {
+	Discovery discovery.DiscoveryInterface
+	Client    dynamic.Interface
+	// Cluster is the name of the cluster in paths
+	Cluster string
+	// PathTemplate is the path each resource is verified at, {cluster}, {namespace}, {kind} and {name} are
+	// replaced by the name of the cluster and the namespace, kind and name of the resource
+	PathTemplate string
+	// Namespace limits the audit to the resources of a namespace, cluster-scoped resources are skipped
+	Namespace string
+}
+
+// NewAuditor creates an auditor of the cluster of a client config
+func NewAuditor(config *rest.Config, cluster string) (*Auditor, error) {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create discovery client: %v", err)
+	}
+	client, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create client: %v", err)
+	}
+	return &Auditor{Discovery: discoveryClient, Client: client, Cluster: cluster, PathTemplate: DefaultPathTemplate}, nil
+}
+
+// apiResource is a resource of the API that can be listed
+type apiResource struct {
+	gvr        schema.GroupVersionResource
+	namespaced bool
+}
+
+// Audit lists the resources of the kinds the ruleset verifies or refers to and verifies them with up to jobs
+// paths verified concurrently. Kinds that the cluster does not serve are skipped and returned.
+func (a *Auditor) Audit(ruleSet *verifier.CompiledRuleSet, jobs int) (verifier.Report, []string, error) {
+	kinds := ruleSet.Kinds()
+	served, err := a.resources()
+	if err != nil {
+		return verifier.Report{}, nil, err
+	}
+
+	skipped := []string{}
+	resources := map[string][]map[string]interface{}{}
+	for _, kind := range kinds {
+		if len(served[kind]) == 0 {
+			skipped = append(skipped, kind)
+			continue
+		}
+		// Objects that are served by several groups are verified once, in the first group that lists them
+		listed := map[string]bool{}
+		for _, r := range served[kind] {
+			if !r.namespaced && a.Namespace != "" {
+				continue
+			}
+			items, err := a.list(r)
+			if err != nil {
+				return verifier.Report{}, nil, fmt.Errorf("Could not list %v: %v", r.gvr.GroupResource(), err)
+			}
+			for _, item := range items {
+				if uid := uid(item); uid != "" {
+					if listed[uid] {
+						continue
+					}
+					listed[uid] = true
+				}
+				if item["kind"] == nil {
+					item["kind"] = kind
+				}
+				path := a.path(item)
+				resources[path] = append(resources[path], item)
+			}
+		}
+	}
+	report, err := ruleSet.VerifyInputs(verifier.Inputs{Resources: resources}, jobs)
+	return report, skipped, err
+}
+
+// Returns the resources of the API by kind, one for every group that serves the kind, in the preferred version
+// of the group. Kinds are not unique across groups, such as the kinds of custom resources.
+func (a *Auditor) resources() (map[string][]apiResource, error) {
+	groups, lists, err := a.Discovery.ServerGroupsAndResources()
+	if err != nil && !discovery.IsGroupDiscoveryFailedError(err) {
+		return nil, fmt.Errorf("Could not discover the resources of the cluster: %v", err)
+	}
+	preferred := map[string]bool{}
+	for _, group := range groups {
+		preferred[group.PreferredVersion.GroupVersion] = true
+	}
+	// Lists of preferred versions go first, so that their resources are used for kinds served in several versions
+	sort.SliceStable(lists, func(i, j int) bool {
+		return preferred[lists[i].GroupVersion] && !preferred[lists[j].GroupVersion]
+	})
+
+	resources := map[string][]apiResource{}
+	found := map[schema.GroupKind]bool{}
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, r := range list.APIResources {
+			if strings.Contains(r.Name, "/") || !hasVerb(r.Verbs, "list") {
+				continue
+			}
+			if groupKind := gv.WithKind(r.Kind).GroupKind(); !found[groupKind] {
+				found[groupKind] = true
+				resources[r.Kind] = append(resources[r.Kind], apiResource{gvr: gv.WithResource(r.Name), namespaced: r.Namespaced})
+			}
+		}
+	}
+	return resources, nil
+}
+
+// Lists the resources of an API resource page by page, without their managed fields
+func (a *Auditor) list(r apiResource) ([]map[string]interface{}, error) {
+	var client dynamic.ResourceInterface = a.Client.Resource(r.gvr)
+	if r.namespaced && a.Namespace != "" {
+		client = a.Client.Resource(r.gvr).Namespace(a.Namespace)
+	}
+
+	items := []map[string]interface{}{}
+	options := metav1.ListOptions{Limit: listLimit}
+	for {
+		list, err := client.List(context.Background(), options)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list.Items {
+			item.SetManagedFields(nil)
+			items = append(items, item.Object)
+		}
+		options.Continue = list.GetContinue()
+		if options.Continue == "" {
+			return items, nil
+		}
+	}
+}
+
+// Returns the path a resource is verified at
+func (a *Auditor) path(resource map[string]interface{}) string {
+	template := a.PathTemplate
+	if template == "" {
+		template = DefaultPathTemplate
+	}
+	namespace := ClusterScope
+	var name interface{}
+	if md, ok := resource["metadata"].(map[string]interface{}); ok {
+		if ns, ok := md["namespace"].(string); ok && ns != "" {
+			namespace = ns
+		}
+		name = md["name"]
+	}
+	return strings.NewReplacer(
+		"{cluster}", a.Cluster,
+		"{namespace}", namespace,
+		"{kind}", fmt.Sprintf("%v", resource["kind"]),
+		"{name}", fmt.Sprintf("%v", name),
+	).Replace(template)
+}
+
+// Returns the uid of a resource, or "" if it has none
+func uid(resource map[string]interface{}) string {
+	if md, ok := resource["metadata"].(map[string]interface{}); ok {
+		if uid, ok := md["uid"].(string); ok {
+			return uid
+		}
+	}
+	return ""
+}
+
+// Checks if an API resource supports a verb
+func hasVerb(verbs metav1.Verbs, verb string) bool {
+	for _, v := range verbs {
+		if v == verb {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package audit
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	fakedynamic "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"github.com/wish/gatekeeper/verifier"
+)
+
+// Returns a resource of the fake cluster
+func testResource(apiVersion string, kind string, namespace string, name string, labels map[string]interface{}) *unstructured.Unstructured {
+	metadata := map[string]interface{}{"name": name, "labels": labels}
+	if namespace != "" {
+		metadata["namespace"] = namespace
+	}
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiVersion,
+		"kind":       kind,
+		"metadata":   metadata,
+	}}
+}
+
+// Returns an auditor of a fake cluster serving namespaces, and deployments in the apps and extensions groups and
+// in a custom resource group
+func testAuditor(objects ...runtime.Object) *Auditor {
+	listKinds := map[schema.GroupVersionResource]string{
+		{Group: "apps", Version: "v1", Resource: "deployments"}:            "DeploymentList",
+		{Group: "extensions", Version: "v1beta1", Resource: "deployments"}: "DeploymentList",
+		{Group: "example.com", Version: "v1", Resource: "deployments"}:     "DeploymentList",
+		{Version: "v1", Resource: "namespaces"}:                            "NamespaceList",
+	}
+	discovery := &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: []*metav1.APIResourceList{
+		{
+			GroupVersion: "apps/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "deployments", Kind: "Deployment", Namespaced: true, Verbs: metav1.Verbs{"get", "list"}},
+				{Name: "deployments/status", Kind: "Deployment", Namespaced: true, Verbs: metav1.Verbs{"get"}},
+			},
+		},
+		{
+			GroupVersion: "extensions/v1beta1",
+			APIResources: []metav1.APIResource{
+				{Name: "deployments", Kind: "Deployment", Namespaced: true, Verbs: metav1.Verbs{"get", "list"}},
+			},
+		},
+		{
+			GroupVersion: "example.com/v1",
+			APIResources: []metav1.APIResource{
+				{Name: "deployments", Kind: "Deployment", Namespaced: true, Verbs: metav1.Verbs{"get", "list"}},
+			},
+		},
+		{
+			GroupVersion: "v1",
+			APIResources: []metav1.APIResource{
+				{Name: "namespaces", Kind: "Namespace", Verbs: metav1.Verbs{"get", "list"}},
+			},
+		},
+	}}}
+	return &Auditor{
+		Discovery:    discovery,
+		Client:       fakedynamic.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), listKinds, objects...),
+		Cluster:      "prod",
+		PathTemplate: DefaultPathTemplate,
+	}
+}
+
+func TestAudit(t *testing.T) {
+	ruleSet := verifier.RuleSet{
+		Rules: []verifier.Rule{
+			{
+				Name:  "team-label",
+				Regex: ".*",
+				Kind:  "Deployment",
+				Type:  "allow",
+				RuleTree: map[string]interface{}{
+					"metadata": map[string]interface{}{
+						"labels": map[string]interface{}{
+							"team": map[string]interface{}{"gatekeeper": true, "operation": "path", "index": 1},
+						},
+					},
+				},
+			},
+			{Name: "namespace-name", Regex: ".*", Kind: "Namespace", Type: "allow", RuleTree: map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": map[string]interface{}{"gatekeeper": true, "operation": "path", "index": 0},
+				},
+			}},
+			{Name: "crons", Regex: ".*", Kind: "CronJob", Type: "allow"},
+		},
+	}
+	compiled, errs := verifier.Compile(ruleSet)
+	if len(errs) > 0 {
+		t.Fatalf("Unexpected errors compiling ruleset: %v", errs)
+	}
+
+	auditor := testAuditor(
+		testResource("apps/v1", "Deployment", "web", "frontend", map[string]interface{}{"team": "web"}),
+		testResource("apps/v1", "Deployment", "web", "backend", map[string]interface{}{"team": "api"}),
+		testResource("apps/v1", "Deployment", "api", "backend", map[string]interface{}{"team": "api"}),
+		testResource("v1", "Namespace", "", "web", nil),
+	)
+	report, skipped, err := auditor.Audit(compiled, 2)
+	if err != nil {
+		t.Fatalf("Error auditing cluster: %v", err)
+	}
+	if !reflect.DeepEqual(skipped, []string{"CronJob"}) {
+		t.Errorf("Expected CronJob to be skipped, got %v", skipped)
+	}
+	if len(report.Violations) != 1 {
+		t.Fatalf("Expected 1 violation, got %v", report.Violations)
+	}
+	v := report.Violations[0]
+	if v.Rule != "team-label" || v.Path != "prod/web/backend" || v.Resource == nil || v.Resource.Name != "backend" {
+		t.Errorf("Expected a team-label violation of prod/web/backend, got %v", v)
+	}
+
+	// Namespaced audits skip cluster-scoped resources
+	auditor.Namespace = "api"
+	auditor.PathTemplate = "{cluster}/{namespace}/{kind}/{name}"
+	report, _, err = auditor.Audit(compiled, 2)
+	if err != nil {
+		t.Fatalf("Error auditing namespace: %v", err)
+	}
+	if len(report.Violations) != 1 || report.Violations[0].Path != "prod/api/Deployment/backend" {
+		t.Errorf("Expected 1 violation of prod/api/Deployment/backend, got %v", report.Violations)
+	}
+}
+
+func TestAuditGroups(t *testing.T) {
+	compiled, errs := verifier.Compile(verifier.RuleSet{Rules: []verifier.Rule{{
+		Name:  "team-label",
+		Regex: ".*",
+		Kind:  "Deployment",
+		Type:  "allow",
+		RuleTree: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels": map[string]interface{}{
+					"team": map[string]interface{}{"gatekeeper": true, "operation": "path", "index": 1},
+				},
+			},
+		},
+	}}})
+	if len(errs) > 0 {
+		t.Fatalf("Unexpected errors compiling ruleset: %v", errs)
+	}
+
+	// The same deployment is served by the apps and extensions groups, another kind of deployment by a custom group
+	frontend := testResource("apps/v1", "Deployment", "web", "frontend", map[string]interface{}{"team": "web"})
+	frontend.SetUID("frontend")
+	legacyFrontend := testResource("extensions/v1beta1", "Deployment", "web", "frontend", map[string]interface{}{"team": "web"})
+	legacyFrontend.SetUID("frontend")
+	custom := testResource("example.com/v1", "Deployment", "web", "backend", map[string]interface{}{"team": "api"})
+	custom.SetUID("backend")
+
+	report, skipped, err := testAuditor(frontend, legacyFrontend, custom).Audit(compiled, 2)
+	if err != nil {
+		t.Fatalf("Error auditing cluster: %v", err)
+	}
+	if len(skipped) != 0 {
+		t.Errorf("Expected no kind to be skipped, got %v", skipped)
+	}
+	if len(report.Violations) != 1 || report.Violations[0].Rule != "team-label" || report.Violations[0].Path != "prod/web/backend" {
+		t.Errorf("Expected only a team-label violation of prod/web/backend, got %v", report.Violations)
+	}
+}
+
+func TestPath(t *testing.T) {
+	auditor := &Auditor{Cluster: "prod"}
+	namespace := testResource("v1", "Namespace", "", "web", nil).Object
+	if path := auditor.path(namespace); path != "prod/"+ClusterScope+"/web" {
+		t.Errorf("Expected cluster-scoped resources to be verified at prod/%v/web, got %v", ClusterScope, path)
+	}
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/wish/gatekeeper/audit"
+	"github.com/wish/gatekeeper/output"
+	"github.com/wish/gatekeeper/verifier"
+)
+
+var auditKubeconfig string
+var auditContext string
+var auditCluster string
+var auditNamespace string
+var auditPathTemplate string
+var auditOutputFormat string
+var auditFailOn string
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Verify the resources of a live cluster against a ruleset",
+	Long: `List the resources of the kinds a ruleset verifies or refers to from the Kubernetes API and verify them.
+Each resource is verified at the path given by --path-template, which PATH() and the rule regexes match.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !verifier.ValidSeverity(auditFailOn) {
+			fmt.Println("--fail-on must be one of error, warning or info.")
+			os.Exit(1)
+		}
+		ruleSet := parseRulesets(rulesetPaths)
+		compiled, errs := verifier.Compile(ruleSet)
+
+		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+		loadingRules.ExplicitPath = auditKubeconfig
+		clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{CurrentContext: auditContext})
+		config, err := clientConfig.ClientConfig()
+		if err != nil {
+			fmt.Println("Error loading kubeconfig: " + err.Error())
+			os.Exit(1)
+		}
+		cluster := auditCluster
+		if cluster == "" {
+			rawConfig, err := clientConfig.RawConfig()
+			if err != nil {
+				fmt.Println("Error loading kubeconfig: " + err.Error())
+				os.Exit(1)
+			}
+			cluster = rawConfig.CurrentContext
+			if auditContext != "" {
+				cluster = auditContext
+			}
+		}
+
+		auditor, err := audit.NewAuditor(config, cluster)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		auditor.PathTemplate = auditPathTemplate
+		auditor.Namespace = auditNamespace
+		report, skipped, err := auditor.Audit(compiled, jobs)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		if len(skipped) > 0 {
+			fmt.Fprintf(os.Stderr, "Skipped kinds the cluster does not serve: %v\n", strings.Join(skipped, ", "))
+		}
+
+		report.Violations = append(verifier.NewReport(errs).Violations, report.Violations...)
+		if err := output.Write(os.Stdout, auditOutputFormat, report.Unsuppressed()); err != nil {
+			fmt.Println("Error writing output: " + err.Error())
+			os.Exit(1)
+		}
+		if suppressed := report.Suppressed(); suppressed > 0 {
+			fmt.Fprintf(os.Stderr, "%v violation(s) suppressed by annotations or exemptions\n", suppressed)
+		}
+		if report.Fails(auditFailOn) {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.Flags().StringVar(&auditKubeconfig, "kubeconfig", "", "Path of the kubeconfig file, defaults to $KUBECONFIG or ~/.kube/config")
+	auditCmd.Flags().StringVar(&auditContext, "context", "", "Kubeconfig context to use, defaults to the current context")
+	auditCmd.Flags().StringVar(&auditCluster, "cluster", "", "Name of the cluster in paths, defaults to the name of the context")
+	auditCmd.Flags().StringVarP(&auditNamespace, "namespace", "n", "", "Only audit the resources of this namespace")
+	auditCmd.Flags().StringVar(&auditPathTemplate, "path-template", audit.DefaultPathTemplate, "Path each resource is verified at, with {cluster}, {namespace}, {kind} and {name} replaced")
+	auditCmd.Flags().IntVarP(&jobs, "jobs", "j", runtime.NumCPU(), "Number of paths to verify concurrently")
+	auditCmd.Flags().StringVar(&auditFailOn, "fail-on", verifier.SeverityError, "Lowest severity of violation that fails the run, one of: error, warning, info")
+	auditCmd.Flags().StringVarP(&auditOutputFormat, "output", "o", "text", "Output format, one of: text, json, jsonl, sarif, junit")
+}
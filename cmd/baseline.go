@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wish/gatekeeper/verifier"
+)
+
+var baselineFile string
+
+var baselineCmd = &cobra.Command{
+	Use:   "baseline [files or folders]",
+	Short: "Record the current violations of files and folders in a baseline file",
+	Long: `Record the current violations of files and folders, or of resources read from stdin with -, in a baseline
+file. Later runs with --baseline only report violations that are not in the baseline, and the baseline entries
+that are fixed.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ruleSet := parseRulesets(rulesetPaths)
+		report, err := verify(ruleSet, args, nil)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		baseline := verifier.NewBaseline(report)
+		f, err := os.Create(baselineFile)
+		if err != nil {
+			fmt.Printf("Error writing %v: %v\n", baselineFile, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		if err := baseline.Write(f); err != nil {
+			fmt.Printf("Error writing %v: %v\n", baselineFile, err)
+			os.Exit(1)
+		}
+		fmt.Printf("Recorded %v violation(s) in %v\n", len(report.Unsuppressed()), baselineFile)
+	},
+}
+
+// Reads a baseline file, exits if it cannot be read
+func readBaseline(path string) verifier.Baseline {
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Printf("Error reading %v: %v\n", path, err)
+		os.Exit(1)
+	}
+	defer f.Close()
+	baseline, err := verifier.ReadBaseline(f)
+	if err != nil {
+		fmt.Printf("Error reading %v: %v\n", path, err)
+		os.Exit(1)
+	}
+	return baseline
+}
+
+// Prints the baseline entries that are fixed to stderr, so that the baseline can be shrunk
+func printFixed(path string, fixed []verifier.BaselineEntry) {
+	if len(fixed) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%v baseline entries of %v are fixed, run gatekeeper baseline to remove them:\n", len(fixed), path)
+	for _, entry := range fixed {
+		rule := entry.Rule
+		if rule == "" {
+			rule = entry.Message
+		}
+		resource := entry.Path
+		if entry.Kind != "" {
+			resource = entry.Kind + " " + entry.Namespace + "/" + entry.Name
+		}
+		line := "  " + rule + ": " + resource
+		if entry.Key != "" {
+			line += " " + entry.Key
+		}
+		if entry.Count > 1 {
+			line += fmt.Sprintf(" (%v)", entry.Count)
+		}
+		fmt.Fprintln(os.Stderr, line)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(baselineCmd)
+	baselineCmd.Flags().StringVar(&baselineFile, "file", "gatekeeper-baseline.json", "Path of the baseline file to write")
+	addInputFlags(baselineCmd)
+}
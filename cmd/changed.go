@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Returns the files changed since the merge base of ref and HEAD, including uncommitted and untracked
+// files but not deleted ones, relative to the working directory
+func changedSince(ref string) ([]string, error) {
+	mergeBase, err := git("merge-base", ref, "HEAD")
+	if err != nil {
+		return nil, err
+	}
+	changed, err := git("diff", "--name-only", "--relative", "--diff-filter=d", strings.TrimSpace(mergeBase))
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git("ls-files", "--others", "--exclude-standard")
+	if err != nil {
+		return nil, err
+	}
+	return readFileList(strings.NewReader(changed + untracked))
+}
+
+// Runs git in the working directory and returns its output
+func git(args ...string) (string, error) {
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("Error running git %v: %v", strings.Join(args, " "), strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("Error running git %v: %v", strings.Join(args, " "), err)
+	}
+	return string(out), nil
+}
+
+// Returns the files listed in path, one per line, or in stdin if path is -
+func filesFrom(path string) ([]string, error) {
+	if path == "-" {
+		return readFileList(os.Stdin)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %v: %v", path, err)
+	}
+	defer f.Close()
+	return readFileList(f)
+}
+
+// Reads a list of files, one per line, skipping blank lines
+func readFileList(r io.Reader) ([]string, error) {
+	files := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			files = append(files, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading file list: %v", err)
+	}
+	return files, nil
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wish/gatekeeper/fix"
+	"github.com/wish/gatekeeper/render"
+	"github.com/wish/gatekeeper/verifier"
+)
+
+var fixDiff bool
+
+var fixCmd = &cobra.Command{
+	Use:   "fix [files or folders]",
+	Short: "Fix the violations of files and folders that have a fix",
+	Long: `Verify files and folders and apply the fixes of their violations to the manifests in place, keeping the
+order of keys and the formatting of the files. Violations have a fix if they are reported by EQ(), TAG() or PATH()
+with fix=true, or by DEFAULT(). Use --diff to print a unified diff of the fixes instead.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		for _, arg := range args {
+			if arg == "-" {
+				fmt.Println("Resources read from stdin cannot be fixed in place.")
+				os.Exit(1)
+			}
+			if render.Kind(arg) != "" {
+				fmt.Println("Helm charts and kustomizations are rendered and cannot be fixed in place.")
+				os.Exit(1)
+			}
+		}
+		ruleSet := parseRulesets(rulesetPaths)
+		report, err := verifyInputs(ruleSet, verifier.Inputs{Paths: args, Changed: changedFiles()})
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		// Group the fixes by file, in the order of the report
+		paths := []string{}
+		fixes := map[string][]*verifier.Violation{}
+		for _, v := range report.Unsuppressed() {
+			if v.Fix == nil {
+				continue
+			}
+			if _, ok := fixes[v.Path]; !ok {
+				paths = append(paths, v.Path)
+			}
+			fixes[v.Path] = append(fixes[v.Path], v)
+		}
+
+		applied, files, failed := 0, 0, 0
+		for _, path := range paths {
+			content, err := ioutil.ReadFile(path)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error reading %v: %v\n", path, err)
+				failed += len(fixes[path])
+				continue
+			}
+			fixed, n, errs := fix.Apply(content, fixes[path])
+			for _, err := range errs {
+				fmt.Fprintf(os.Stderr, "%v: %v\n", path, err)
+			}
+			failed += len(fixes[path]) - n
+			if n == 0 {
+				continue
+			}
+			applied += n
+			files++
+			if fixDiff {
+				fmt.Print(fix.Diff(path, content, fixed))
+				continue
+			}
+			if err := writeFile(path, fixed); err != nil {
+				fmt.Fprintf(os.Stderr, "Error writing %v: %v\n", path, err)
+				os.Exit(1)
+			}
+		}
+
+		verb := "Fixed"
+		if fixDiff {
+			verb = "Would fix"
+		}
+		fmt.Fprintf(os.Stderr, "%v %v violation(s) in %v file(s)\n", verb, applied, files)
+		if failed > 0 {
+			fmt.Fprintf(os.Stderr, "%v fix(es) could not be applied\n", failed)
+			os.Exit(1)
+		}
+	},
+}
+
+// Writes content to an existing file, keeping its permissions
+func writeFile(path string, content []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, info.Mode())
+}
+
+func init() {
+	rootCmd.AddCommand(fixCmd)
+	fixCmd.Flags().BoolVar(&fixDiff, "diff", false, "Print a unified diff of the fixes instead of writing them")
+	fixCmd.Flags().IntVarP(&jobs, "jobs", "j", runtime.NumCPU(), "Number of files to verify concurrently")
+	fixCmd.Flags().StringVar(&changedSinceRef, "changed-since", "", "Only fix the files changed since the git ref")
+	fixCmd.Flags().StringVar(&filesFromPath, "files-from", "", "Only fix the files listed in this file, one per line, or - for stdin")
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wish/gatekeeper/output"
+	"github.com/wish/gatekeeper/verifier"
+)
+
+var lintOutputFormat string
+var lintFailOn string
+
+var lintCmd = &cobra.Command{
+	Use:   "lint-ruleset [ruleset...]",
+	Short: "Check a ruleset for mistakes without verifying any files",
+	Long: `Evaluate a ruleset and check it for unknown fields, missing fields, invalid types and severities,
+invalid regexes, unknown operations, negative PATH() and INDEX() indexes and rules that can never match.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !verifier.ValidSeverity(lintFailOn) {
+			fmt.Println("--fail-on must be one of error, warning or info.")
+			os.Exit(1)
+		}
+		paths := append(rulesetPaths, args...)
+		if len(paths) == 0 {
+			fmt.Println("You must pass a ruleset with --ruleset or as an argument.")
+			os.Exit(1)
+		}
+
+		report, err := newLoader().LintRulesets(paths)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+
+		if err := output.Write(os.Stdout, lintOutputFormat, report.Violations); err != nil {
+			fmt.Println("Error writing output: " + err.Error())
+			os.Exit(1)
+		}
+		if report.Fails(lintFailOn) {
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lintCmd)
+	lintCmd.Flags().StringVar(&lintFailOn, "fail-on", verifier.SeverityWarning, "Lowest severity of finding that fails the lint, one of: error, warning, info")
+	lintCmd.Flags().StringVarP(&lintOutputFormat, "output", "o", "text", "Output format, one of: text, json, jsonl, sarif, junit")
+}
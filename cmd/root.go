@@ -2,48 +2,262 @@ package cmd
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
-	"strconv"
+	"runtime"
+	"strings"
 
-	"github.com/gobuffalo/packr"
 	"github.com/spf13/cobra"
 
+	"github.com/wish/gatekeeper/output"
+	"github.com/wish/gatekeeper/parser"
+	"github.com/wish/gatekeeper/render"
 	"github.com/wish/gatekeeper/verifier"
 )
 
-var rulesetPath string
+var rulesetPaths []string
+var jsonnetPaths []string
+var extStrs []string
+var tlaStrs []string
+var outputFormat string
+var failOn string
+var showSuppressed bool
+var jobs int
+var changedSinceRef string
+var filesFromPath string
+var baselinePath string
+var valuesFiles []string
+var releaseName string
+var releaseNamespace string
+var stdinPath string
 
 var rootCmd = &cobra.Command{
 	Use:   "gatekeeper",
 	Short: "Gatekeeper verifies your Kubernetes files against custom rulesets",
-	Long:  `Verify your Kubernetes files using custom rulesets.`,
+	Long: `Verify your Kubernetes files using custom rulesets. Pass one or more files and folders, or - to read
+a stream of resources from stdin, which are verified together as if they were a single folder.`,
+	// Allow the file and folder arguments alongside subcommands
+	Args: pathArgs,
 	Run: func(cmd *cobra.Command, args []string) {
-		if len(args) == 1 {
-			// Get gatekeeper function definitions
-			box := packr.NewBox("../function_definitions")
-			gatekeeperFunctions, err := box.FindString("gatekeeper.jsonnet")
+		if !verifier.ValidSeverity(failOn) {
+			fmt.Println("--fail-on must be one of error, warning or info.")
+			os.Exit(1)
+		}
+		if len(args) > 0 {
+			// Parse ruleset
+			ruleSet := parseRulesets(rulesetPaths)
+
+			// Verify files and folders, or only their changed files
+			changed := changedFiles()
+			report, err := verify(ruleSet, args, changed)
 			if err != nil {
-				fmt.Println("Error: Could not get gatekeeper.jsonnet from packr.")
+				fmt.Println(err.Error())
 				os.Exit(1)
 			}
 
-			// Parse ruleset
-			ruleSet := verifier.ParseRuleset(rulesetPath, gatekeeperFunctions)
-
-			// Verify folder
-			if errs := verifier.Verify(ruleSet, args[0]); len(errs) > 0 {
-				for i, err := range errs {
-					fmt.Println(strconv.Itoa(i+1) + ". " + err.Error())
+			// Only report new violations, and the baseline entries that are fixed if every file was verified
+			if baselinePath != "" {
+				fixed := readBaseline(baselinePath).Apply(report)
+				if changed == nil {
+					printFixed(baselinePath, fixed)
 				}
+			}
+			violations := report.Unsuppressed()
+			if showSuppressed {
+				violations = report.Violations
+			}
+			if err := output.Write(os.Stdout, outputFormat, violations); err != nil {
+				fmt.Println("Error writing output: " + err.Error())
+				os.Exit(1)
+			}
+			if suppressed := report.Suppressed(); suppressed > 0 {
+				fmt.Fprintf(os.Stderr, "%v violation(s) suppressed by annotations, exemptions or the baseline\n", suppressed)
+			}
+			if report.Fails(failOn) {
 				os.Exit(1)
 			}
 		} else {
-			fmt.Println("You must pass exactly one argument.")
+			fmt.Println("You must pass at least one file or folder, or - to read from stdin.")
 			os.Exit(1)
 		}
 	},
 }
 
+// Accepts files, folders and -, but reports an argument that does not exist and is close to the name of a
+// subcommand as an unknown command, as cobra does for commands without arguments
+func pathArgs(cmd *cobra.Command, args []string) error {
+	if cmd.SuggestionsMinimumDistance <= 0 {
+		cmd.SuggestionsMinimumDistance = 2
+	}
+	for _, arg := range args {
+		if arg == "-" {
+			continue
+		}
+		if _, err := os.Stat(arg); err == nil {
+			continue
+		}
+		if suggestions := cmd.SuggestionsFor(arg); len(suggestions) > 0 {
+			return fmt.Errorf("unknown command %q for %q\n\nDid you mean this?\n\t%v\n", arg, cmd.CommandPath(), strings.Join(suggestions, "\n\t"))
+		}
+	}
+	return nil
+}
+
+// Verifies files and folders together, or only their changed files unless changed is nil. An argument of - reads
+// a stream of resources from stdin, which is verified at --stdin-path. Helm charts and kustomizations are
+// rendered and their resources verified at the paths of their templates and files.
+func verify(ruleSet verifier.RuleSet, args []string, changed []string) (verifier.Report, error) {
+	inputs := verifier.Inputs{Resources: map[string][]map[string]interface{}{}, Changed: changed}
+	readStdin := false
+	for _, arg := range args {
+		if arg == "-" {
+			if readStdin {
+				return verifier.Report{}, fmt.Errorf("Stdin can only be read once")
+			}
+			if filesFromPath == "-" {
+				return verifier.Report{}, fmt.Errorf("Stdin cannot be read by both - and --files-from -")
+			}
+			readStdin = true
+			resources, err := readResources(os.Stdin)
+			if err != nil {
+				return verifier.Report{}, err
+			}
+			inputs.Resources[stdinPath] = append(inputs.Resources[stdinPath], resources...)
+			continue
+		}
+		if render.Kind(arg) == "" {
+			inputs.Paths = append(inputs.Paths, arg)
+			continue
+		}
+		if changed != nil {
+			return verifier.Report{}, fmt.Errorf("--changed-since and --files-from cannot be used with Helm charts and kustomizations")
+		}
+		resources, err := render.Render(arg, render.Options{ValuesFiles: valuesFiles, ReleaseName: releaseName, Namespace: releaseNamespace})
+		if err != nil {
+			return verifier.Report{}, err
+		}
+		for path, rendered := range resources {
+			inputs.Resources[path] = append(inputs.Resources[path], rendered...)
+		}
+	}
+	return verifyInputs(ruleSet, inputs)
+}
+
+// Compiles the ruleset and verifies inputs with it, the violations of invalid rules are reported first
+func verifyInputs(ruleSet verifier.RuleSet, inputs verifier.Inputs) (verifier.Report, error) {
+	compiled, errs := verifier.Compile(ruleSet)
+	report, err := compiled.VerifyInputs(inputs, jobs)
+	report.Violations = append(verifier.NewReport(errs).Violations, report.Violations...)
+	return report, err
+}
+
+// Reads a multi-document stream of resources, the items of lists such as the output of kubectl get are
+// read as resources of their own
+func readResources(r io.Reader) ([]map[string]interface{}, error) {
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading stdin: %v", err)
+	}
+	parsed, err := parser.ParseFile(content)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse stdin: %v", err)
+	}
+	resources := []map[string]interface{}{}
+	for _, resource := range parsed {
+		items, isList := resource["items"].([]interface{})
+		if kind, _ := resource["kind"].(string); !isList || !strings.HasSuffix(kind, "List") {
+			resources = append(resources, resource)
+			continue
+		}
+		for _, item := range items {
+			if item, ok := item.(map[string]interface{}); ok {
+				resources = append(resources, item)
+			}
+		}
+	}
+	return resources, nil
+}
+
+// Adds the flags that configure how inputs that are not plain files are read to a command
+func addInputFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&stdinPath, "stdin-path", "stdin.yaml", "Path resources read from stdin are verified at, which rule regexes and PATH() match")
+	addRenderFlags(cmd)
+}
+
+// Adds the flags that configure how Helm charts are rendered to a command
+func addRenderFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVarP(&valuesFiles, "values", "f", nil, "Values file of a Helm chart, repeat to merge values files in order")
+	cmd.Flags().StringVar(&releaseName, "release-name", "release-name", "Name of the release Helm charts are rendered for")
+	cmd.Flags().StringVar(&releaseNamespace, "release-namespace", "default", "Namespace of the release Helm charts are rendered for")
+}
+
+// Returns the files listed by --changed-since or --files-from, or nil if every file is verified
+func changedFiles() []string {
+	if changedSinceRef != "" && filesFromPath != "" {
+		fmt.Println("--changed-since and --files-from cannot be used together.")
+		os.Exit(1)
+	}
+	var changed []string
+	var err error
+	if changedSinceRef != "" {
+		changed, err = changedSince(changedSinceRef)
+	} else if filesFromPath != "" {
+		changed, err = filesFrom(filesFromPath)
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	return changed
+}
+
+// Creates a ruleset loader with the packaged gatekeeper functions and the jsonnet flags, exits if they are invalid
+func newLoader() *verifier.Loader {
+	gatekeeperFunctions, err := verifier.GatekeeperFunctions()
+	if err != nil {
+		fmt.Println("Error: " + err.Error())
+		os.Exit(1)
+	}
+	loader := verifier.NewLoader(gatekeeperFunctions)
+	loader.LibraryPaths = jsonnetPaths
+	loader.ExtVars = parseVars("--ext-str", extStrs)
+	loader.TLAVars = parseVars("--tla-str", tlaStrs)
+	return loader
+}
+
+// Parses key=value jsonnet variables, a variable without a value is read from the environment
+func parseVars(flag string, vars []string) map[string]string {
+	parsed := map[string]string{}
+	for _, v := range vars {
+		parts := strings.SplitN(v, "=", 2)
+		if parts[0] == "" {
+			fmt.Printf("%v must be of the form key=value, got %v\n", flag, v)
+			os.Exit(1)
+		}
+		if len(parts) == 1 {
+			val, ok := os.LookupEnv(parts[0])
+			if !ok {
+				fmt.Printf("%v %v has no value and is not set in the environment\n", flag, v)
+				os.Exit(1)
+			}
+			parts = append(parts, val)
+		}
+		parsed[parts[0]] = parts[1]
+	}
+	return parsed
+}
+
+// Parses and merges the ruleset files with the packaged gatekeeper function definitions, exits if they cannot be parsed
+func parseRulesets(paths []string) verifier.RuleSet {
+	ruleSet, err := newLoader().ParseRulesets(paths)
+	if err != nil {
+		fmt.Println(err.Error())
+		os.Exit(1)
+	}
+	return ruleSet
+}
+
 // Execute executes the root command
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
@@ -53,7 +267,18 @@ func Execute() {
 
 func init() {
 	cobra.OnInitialize(initConfig)
-	rootCmd.Flags().StringVarP(&rulesetPath, "ruleset", "r", "", "Path to the ruleset jsonnet file")
+	rootCmd.PersistentFlags().StringArrayVarP(&rulesetPaths, "ruleset", "r", nil, "Ruleset jsonnet file, folder or glob, repeat to merge rulesets in order")
+	rootCmd.PersistentFlags().StringArrayVarP(&jsonnetPaths, "jpath", "J", nil, "Library path searched for jsonnet imports, can be repeated")
+	rootCmd.PersistentFlags().StringArrayVar(&extStrs, "ext-str", nil, "Jsonnet external variable as key=value, or key to read it from the environment")
+	rootCmd.PersistentFlags().StringArrayVar(&tlaStrs, "tla-str", nil, "Jsonnet top-level argument as key=value, or key to read it from the environment")
+	rootCmd.Flags().StringVar(&failOn, "fail-on", verifier.SeverityError, "Lowest severity of violation that fails the run, one of: error, warning, info")
+	rootCmd.Flags().BoolVar(&showSuppressed, "show-suppressed", false, "Include suppressed violations in the output")
+	rootCmd.Flags().IntVarP(&jobs, "jobs", "j", runtime.NumCPU(), "Number of files to verify concurrently")
+	rootCmd.Flags().StringVar(&changedSinceRef, "changed-since", "", "Only report violations of the files changed since the git ref")
+	rootCmd.Flags().StringVar(&filesFromPath, "files-from", "", "Only report violations of the files listed in this file, one per line, or - for stdin")
+	rootCmd.Flags().StringVar(&baselinePath, "baseline", "", "Only report violations that are not recorded in this baseline file")
+	rootCmd.Flags().StringVarP(&outputFormat, "output", "o", "text", "Output format, one of: text, json, jsonl, sarif, junit")
+	addInputFlags(rootCmd)
 }
 
 func initConfig() {
@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wish/gatekeeper/verifier"
+	"github.com/wish/gatekeeper/webhook"
+)
+
+var serveAddr string
+var serveCertFile string
+var serveKeyFile string
+var serveSelfSigned bool
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve a Kubernetes validating admission webhook",
+	Long:  `Serve a Kubernetes validating admission webhook that verifies admitted objects against the ruleset.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		ruleSet := parseRulesets(rulesetPaths)
+		compiled, errs := verifier.Compile(ruleSet)
+		if len(errs) > 0 {
+			for _, err := range errs {
+				fmt.Println(err.Error())
+			}
+			fmt.Println("The ruleset is invalid.")
+			os.Exit(1)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/validate", webhook.NewHandler(compiled))
+		mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("ok"))
+		})
+		server := &http.Server{Addr: serveAddr, Handler: mux}
+
+		var err error
+		if serveSelfSigned {
+			cert, certErr := webhook.SelfSignedCertificate([]string{"localhost", "127.0.0.1"})
+			if certErr != nil {
+				fmt.Println("Error: " + certErr.Error())
+				os.Exit(1)
+			}
+			server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+			fmt.Println("Serving with a self-signed certificate on " + serveAddr)
+			err = server.ListenAndServeTLS("", "")
+		} else if serveCertFile != "" && serveKeyFile != "" {
+			fmt.Println("Serving on " + serveAddr)
+			err = server.ListenAndServeTLS(serveCertFile, serveKeyFile)
+		} else {
+			fmt.Println("You must pass --tls-cert-file and --tls-private-key-file, or --self-signed.")
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Println("Error serving webhook: " + err.Error())
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+	serveCmd.Flags().StringVar(&serveAddr, "addr", ":8443", "Address to listen on")
+	serveCmd.Flags().StringVar(&serveCertFile, "tls-cert-file", "", "Path to the TLS certificate")
+	serveCmd.Flags().StringVar(&serveKeyFile, "tls-private-key-file", "", "Path to the TLS private key")
+	serveCmd.Flags().BoolVar(&serveSelfSigned, "self-signed", false, "Serve with a generated self-signed certificate, for local testing")
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/wish/gatekeeper/verifier"
+)
+
+var testVerbose bool
+
+var testCmd = &cobra.Command{
+	Use:   "test [folder...]",
+	Short: "Run the unit tests of a ruleset",
+	Long: `Run the test cases of the *_test.jsonnet files in the given folders, or next to the ruleset files if no folder is given.
+Each test case verifies resources and checks the violations reported by each named rule.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(rulesetPaths) == 0 {
+			fmt.Println("You must pass a ruleset with --ruleset.")
+			os.Exit(1)
+		}
+		loader := newLoader()
+		ruleSet, err := loader.ParseRulesets(rulesetPaths)
+		if err != nil {
+			fmt.Println(err.Error())
+			os.Exit(1)
+		}
+		compiled, errs := verifier.Compile(ruleSet)
+		if len(errs) > 0 {
+			for _, err := range errs {
+				fmt.Println(err.Error())
+			}
+			fmt.Println("The ruleset is invalid.")
+			os.Exit(1)
+		}
+
+		dirs := args
+		if len(dirs) == 0 {
+			dirs = rulesetDirs(ruleSet)
+		}
+		passed, failed := 0, 0
+		for _, dir := range dirs {
+			files, err := verifier.FindTests(dir)
+			if err != nil {
+				fmt.Println(err.Error())
+				os.Exit(1)
+			}
+			for _, file := range files {
+				testCases, err := loader.ReadTests(file)
+				if err != nil {
+					fmt.Println("FAIL: " + err.Error())
+					failed++
+					continue
+				}
+				for _, result := range compiled.RunTests(file, testCases) {
+					if result.Passed() {
+						passed++
+						if testVerbose {
+							fmt.Printf("PASS: %v: %v\n", result.File, result.Name)
+						}
+						continue
+					}
+					failed++
+					fmt.Printf("FAIL: %v: %v\n", result.File, result.Name)
+					if result.Err != nil {
+						fmt.Println("  " + result.Err.Error())
+					}
+					for _, diff := range result.Diffs {
+						fmt.Println("  " + strings.Replace(diff, "\n", "\n    ", -1))
+					}
+				}
+			}
+		}
+
+		fmt.Printf("%v passed, %v failed\n", passed, failed)
+		if failed > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+// Returns the folders of the ruleset files the rules were read from, in order
+func rulesetDirs(ruleSet verifier.RuleSet) []string {
+	dirs := []string{}
+	seen := map[string]bool{}
+	for _, rule := range ruleSet.Rules {
+		dir := filepath.Dir(rule.Source)
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func init() {
+	rootCmd.AddCommand(testCmd)
+	testCmd.Flags().BoolVarP(&testVerbose, "verbose", "v", false, "Also print the test cases that pass")
+}
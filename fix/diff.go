@@ -0,0 +1,146 @@
+package fix
+
+import (
+	"fmt"
+	"strings"
+)
+
+// contextLines is the number of unchanged lines shown around the changes of a diff
+const contextLines = 3
+
+// diffLine is a line of a diff, op is ' ' for unchanged lines, '-' for removed and '+' for added lines
+type diffLine struct {
+	op   byte
+	text string
+	// a and b are the 0-based line numbers of the line before and after the change
+	a int
+	b int
+}
+
+// Diff returns the unified diff between the content of a file before and after fixing it, or an
+// empty string if they are equal
+func Diff(path string, before []byte, after []byte) string {
+	if string(before) == string(after) {
+		return ""
+	}
+	lines := diffLines(splitLines(string(before)), splitLines(string(after)))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- a/%v\n+++ b/%v\n", path, path)
+	for start := 0; start < len(lines); {
+		if lines[start].op == ' ' {
+			start++
+			continue
+		}
+		// A hunk runs from the context before a change to the context after the last change that is
+		// separated by at most two contexts of unchanged lines
+		end := start
+		for i := start; i < len(lines) && i <= end+2*contextLines+1; i++ {
+			if lines[i].op != ' ' {
+				end = i
+			}
+		}
+		from := start - contextLines
+		if from < 0 {
+			from = 0
+		}
+		to := end + contextLines + 1
+		if to > len(lines) {
+			to = len(lines)
+		}
+		writeHunk(&b, lines[from:to])
+		start = to
+	}
+	return b.String()
+}
+
+// Writes a hunk with its header, which holds the 1-based start line and the line count of each side
+func writeHunk(b *strings.Builder, lines []diffLine) {
+	aStart, bStart, aCount, bCount := lines[0].a, lines[0].b, 0, 0
+	for _, line := range lines {
+		if line.op != '+' {
+			aCount++
+		}
+		if line.op != '-' {
+			bCount++
+		}
+	}
+	if aCount > 0 {
+		aStart++
+	}
+	if bCount > 0 {
+		bStart++
+	}
+	fmt.Fprintf(b, "@@ -%v,%v +%v,%v @@\n", aStart, aCount, bStart, bCount)
+	for _, line := range lines {
+		b.WriteByte(line.op)
+		b.WriteString(line.text)
+		if !strings.HasSuffix(line.text, "\n") {
+			b.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+}
+
+// Splits text into lines that keep their newline
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Returns the lines of a diff between a and b. Common leading and trailing lines are skipped before the
+// longest common subsequence of the remaining lines is computed, as fixes only change a few lines.
+func diffLines(a []string, b []string) []diffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	midA, midB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	// lcs[i][j] is the length of the longest common subsequence of midA[i:] and midB[j:]
+	lcs := make([][]int, len(midA)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(midB)+1)
+	}
+	for i := len(midA) - 1; i >= 0; i-- {
+		for j := len(midB) - 1; j >= 0; j-- {
+			if midA[i] == midB[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	lines := []diffLine{}
+	for i := 0; i < prefix; i++ {
+		lines = append(lines, diffLine{op: ' ', text: a[i], a: i, b: i})
+	}
+	i, j := 0, 0
+	for i < len(midA) || j < len(midB) {
+		switch {
+		case i < len(midA) && j < len(midB) && midA[i] == midB[j]:
+			lines = append(lines, diffLine{op: ' ', text: midA[i], a: prefix + i, b: prefix + j})
+			i++
+			j++
+		case j < len(midB) && (i == len(midA) || lcs[i][j+1] > lcs[i+1][j]):
+			lines = append(lines, diffLine{op: '+', text: midB[j], a: prefix + i, b: prefix + j})
+			j++
+		default:
+			lines = append(lines, diffLine{op: '-', text: midA[i], a: prefix + i, b: prefix + j})
+			i++
+		}
+	}
+	for k := 0; k < suffix; k++ {
+		lines = append(lines, diffLine{op: ' ', text: a[len(a)-suffix+k], a: len(a) - suffix + k, b: len(b) - suffix + k})
+	}
+	return lines
+}
@@ -0,0 +1,358 @@
+package fix
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/wish/gatekeeper/verifier"
+)
+
+// edit replaces the bytes from start to end of a file with text to fix key
+type edit struct {
+	start int
+	end   int
+	text  string
+	key   string
+}
+
+// Apply applies the fixes of the violations to the resources of a JSON or YAML manifest. Values are
+// replaced and missing keys inserted in the original text, so the order of keys, formatting and comments
+// are kept. It returns the fixed content, the number of fixes applied and the fixes that could not be applied.
+func Apply(content []byte, violations []*verifier.Violation) ([]byte, int, []error) {
+	docs, err := parseDocuments(content)
+	if err != nil {
+		return content, 0, []error{err}
+	}
+	lines := lineOffsets(content)
+
+	errs := []error{}
+	edits := []edit{}
+	for _, v := range violations {
+		if v.Fix == nil || v.Resource == nil {
+			continue
+		}
+		root := findResource(docs, *v.Resource)
+		if root == nil {
+			errs = append(errs, fmt.Errorf("Could not fix %v: %v %v/%v not found", v.Fix.Key, v.Resource.Kind, v.Resource.Namespace, v.Resource.Name))
+			continue
+		}
+		e, err := fixEdit(content, lines, root, *v.Fix)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Could not fix %v of %v %v/%v: %v", v.Fix.Key, v.Resource.Kind, v.Resource.Namespace, v.Resource.Name, err))
+			continue
+		}
+		e.key = v.Fix.Key
+		edits = append(edits, e)
+	}
+
+	// Apply edits from the end, so that the offsets of the remaining edits stay valid. Edits that overlap
+	// an edit that was already applied are skipped, unless they are the same edit.
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	fixed := append([]byte{}, content...)
+	applied := 0
+	limit := len(content)
+	var last edit
+	for i, e := range edits {
+		if i > 0 && e.start == last.start && e.end == last.end && e.text == last.text {
+			applied++
+			continue
+		}
+		if e.end > limit {
+			errs = append(errs, fmt.Errorf("Could not fix %v: it conflicts with another fix", e.key))
+			continue
+		}
+		last = e
+		fixed = append(fixed[:e.start], append([]byte(e.text), fixed[e.end:]...)...)
+		limit = e.start
+		applied++
+	}
+	return fixed, applied, errs
+}
+
+// Parses the documents of a manifest into yaml nodes, which keep the position of each key and value
+func parseDocuments(content []byte) ([]*yaml.Node, error) {
+	docs := []*yaml.Node{}
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var doc yaml.Node
+		err := dec.Decode(&doc)
+		if err == io.EOF {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse manifest: %v", err)
+		}
+		if len(doc.Content) > 0 {
+			docs = append(docs, doc.Content[0])
+		}
+	}
+}
+
+// Returns the root mapping of the document that holds the resource, resources without a namespace are in "default"
+func findResource(docs []*yaml.Node, id verifier.ResourceIdentifier) *yaml.Node {
+	for _, root := range docs {
+		if root.Kind != yaml.MappingNode {
+			continue
+		}
+		found := verifier.ResourceIdentifier{Namespace: "default"}
+		if kind := mappingValue(root, "kind"); kind != nil {
+			found.Kind = kind.Value
+		}
+		if md := mappingValue(root, "metadata"); md != nil && md.Kind == yaml.MappingNode {
+			if name := mappingValue(md, "name"); name != nil {
+				found.Name = name.Value
+			}
+			if namespace := mappingValue(md, "namespace"); namespace != nil {
+				found.Namespace = namespace.Value
+			}
+		}
+		if found == id {
+			return root
+		}
+	}
+	return nil
+}
+
+// Returns the value of a key of a mapping node, or nil if it is not set
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// Returns the edit that sets the key of the resource to the value of the fix
+func fixEdit(content []byte, lines []int, root *yaml.Node, fix verifier.Fix) (edit, error) {
+	parent, node, missing, err := resolve(root, fix.Key)
+	if err != nil {
+		return edit{}, err
+	}
+	flow := parent.Style&yaml.FlowStyle != 0
+	if node != nil {
+		return replaceEdit(content, lines, node, fix.Value, flow)
+	}
+	if parent.Kind != yaml.MappingNode {
+		return edit{}, fmt.Errorf("parent of missing key is not an object")
+	}
+	return insertEdit(content, lines, parent, missing, fix.Value, flow)
+}
+
+// Resolves a key of violations, such as spec.template.metadata.labels.app or spec.containers[0].image, in
+// the resource. Map keys may contain dots, so the longest key of a mapping that matches is used. It returns
+// the node holding the value, or if the last key is missing, its name. The parent of the value is always
+// returned.
+func resolve(root *yaml.Node, key string) (*yaml.Node, *yaml.Node, string, error) {
+	parent, node, rest := root, root, key
+	for rest != "" {
+		switch node.Kind {
+		case yaml.MappingNode:
+			match := -1
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				k := node.Content[i].Value
+				if (rest == k || strings.HasPrefix(rest, k+".") || strings.HasPrefix(rest, k+"[")) && (match < 0 || len(k) > len(node.Content[match].Value)) {
+					match = i
+				}
+			}
+			if match < 0 {
+				if strings.Contains(rest, "[") {
+					return nil, nil, "", fmt.Errorf("key %v not found", rest)
+				}
+				return node, nil, rest, nil
+			}
+			rest = strings.TrimPrefix(rest[len(node.Content[match].Value):], ".")
+			parent, node = node, node.Content[match+1]
+		case yaml.SequenceNode:
+			end := strings.Index(rest, "]")
+			if !strings.HasPrefix(rest, "[") || end < 0 {
+				return nil, nil, "", fmt.Errorf("expected array index at %v", rest)
+			}
+			i, err := strconv.Atoi(rest[1:end])
+			if err != nil || i < 0 || i >= len(node.Content) {
+				return nil, nil, "", fmt.Errorf("index %v not found", rest[:end+1])
+			}
+			parent, node = node, node.Content[i]
+			rest = strings.TrimPrefix(rest[end+1:], ".")
+		default:
+			return nil, nil, "", fmt.Errorf("key %v not found", rest)
+		}
+	}
+	return parent, node, "", nil
+}
+
+// Returns the edit that replaces a scalar value
+func replaceEdit(content []byte, lines []int, node *yaml.Node, value interface{}, flow bool) (edit, error) {
+	if node.Kind != yaml.ScalarNode {
+		return edit{}, fmt.Errorf("value is not a scalar")
+	}
+	if node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 || (node.Tag == "!!null" && node.Value == "") {
+		return edit{}, fmt.Errorf("value cannot be replaced in place")
+	}
+	start := offset(content, lines, node.Line, node.Column)
+	end := scalarEnd(content, start, node.Style, flow)
+	if end < 0 {
+		return edit{}, fmt.Errorf("value cannot be replaced in place")
+	}
+	text, err := formatValue(value, flow || node.Style&yaml.DoubleQuotedStyle != 0)
+	if err != nil {
+		return edit{}, err
+	}
+	return edit{start: start, end: end, text: text}, nil
+}
+
+// Returns the edit that inserts a key into a mapping, as the last key of block mappings and as the
+// first key of flow mappings and JSON objects, using the indentation of the existing keys
+func insertEdit(content []byte, lines []int, mapping *yaml.Node, key string, value interface{}, flow bool) (edit, error) {
+	keyText, err := formatValue(key, flow)
+	if err != nil {
+		return edit{}, err
+	}
+	valueText, err := formatValue(value, flow)
+	if err != nil {
+		return edit{}, err
+	}
+	entry := keyText + ": " + valueText
+
+	if flow {
+		brace := offset(content, lines, mapping.Line, mapping.Column)
+		if brace >= len(content) || content[brace] != '{' {
+			return edit{}, fmt.Errorf("object cannot be edited in place")
+		}
+		if len(mapping.Content) == 0 {
+			return edit{start: brace + 1, end: brace + 1, text: entry}, nil
+		}
+		first := offset(content, lines, mapping.Content[0].Line, mapping.Content[0].Column)
+		separator := string(content[brace+1 : first])
+		if strings.TrimSpace(separator) != "" {
+			return edit{}, fmt.Errorf("object cannot be edited in place")
+		}
+		if !strings.Contains(separator, "\n") {
+			separator = " "
+		}
+		return edit{start: first, end: first, text: entry + "," + separator}, nil
+	}
+
+	if len(mapping.Content) == 0 {
+		return edit{}, fmt.Errorf("empty object cannot be edited in place")
+	}
+	first := mapping.Content[0]
+	indent := string(content[lines[first.Line-1]:offset(content, lines, first.Line, first.Column)])
+	if strings.TrimLeft(indent, " ") != "" {
+		// The first key follows a sequence dash, such as "- name: x"
+		indent = strings.Repeat(" ", utf8.RuneCountInString(indent))
+	}
+	line := lastLine(mapping)
+	if line >= len(lines) {
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			return edit{start: len(content), end: len(content), text: "\n" + indent + entry}, nil
+		}
+		return edit{start: len(content), end: len(content), text: indent + entry + "\n"}, nil
+	}
+	return edit{start: lines[line], end: lines[line], text: indent + entry + "\n"}, nil
+}
+
+// Returns the last line of a node and its children
+func lastLine(node *yaml.Node) int {
+	line := node.Line
+	if node.Kind == yaml.ScalarNode {
+		line += strings.Count(strings.TrimRight(node.Value, "\n"), "\n")
+		if node.Style&(yaml.LiteralStyle|yaml.FoldedStyle) != 0 {
+			line++
+		}
+	}
+	for _, child := range node.Content {
+		if l := lastLine(child); l > line {
+			line = l
+		}
+	}
+	return line
+}
+
+// Formats a value as JSON, which is valid in flow mappings and JSON files, or as a single line of YAML
+func formatValue(value interface{}, asJSON bool) (string, error) {
+	if !asJSON {
+		out, err := yaml.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		if text := strings.TrimSuffix(string(out), "\n"); !strings.Contains(text, "\n") {
+			return text, nil
+		}
+	}
+	out, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// Returns the offset that ends the scalar starting at start, or -1 if it spans lines
+func scalarEnd(content []byte, start int, style yaml.Style, flow bool) int {
+	switch {
+	case style&yaml.DoubleQuotedStyle != 0:
+		for i := start + 1; i < len(content); i++ {
+			switch content[i] {
+			case '\\':
+				i++
+			case '"':
+				return i + 1
+			case '\n':
+				return -1
+			}
+		}
+	case style&yaml.SingleQuotedStyle != 0:
+		for i := start + 1; i < len(content); i++ {
+			switch {
+			case content[i] == '\'' && i+1 < len(content) && content[i+1] == '\'':
+				i++
+			case content[i] == '\'':
+				return i + 1
+			case content[i] == '\n':
+				return -1
+			}
+		}
+	default:
+		end := start
+		for end < len(content) && content[end] != '\n' && !(flow && strings.IndexByte(",]}", content[end]) >= 0) {
+			if content[end] == '#' && end > start && (content[end-1] == ' ' || content[end-1] == '\t') {
+				break
+			}
+			end++
+		}
+		for end > start && (content[end-1] == ' ' || content[end-1] == '\t' || content[end-1] == '\r') {
+			end--
+		}
+		return end
+	}
+	return -1
+}
+
+// Returns the offsets at which each line of the content starts
+func lineOffsets(content []byte) []int {
+	lines := []int{0}
+	for i, c := range content {
+		if c == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// Returns the offset of a 1-based line and column, columns count characters
+func offset(content []byte, lines []int, line int, column int) int {
+	o := lines[line-1]
+	for i := 1; i < column && o < len(content); i++ {
+		_, size := utf8.DecodeRune(content[o:])
+		o += size
+	}
+	return o
+}
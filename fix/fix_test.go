@@ -0,0 +1,222 @@
+package fix
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/wish/gatekeeper/verifier"
+)
+
+var testResource = &verifier.ResourceIdentifier{Kind: "Deployment", Namespace: "web", Name: "frontend"}
+
+// Returns a violation of the test resource with a fix
+func fixViolation(key string, value interface{}) *verifier.Violation {
+	return &verifier.Violation{Key: key, Resource: testResource, Fix: &verifier.Fix{Key: key, Value: value}}
+}
+
+func TestApplyYAML(t *testing.T) {
+	content := `# frontend
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: frontend
+  namespace: web
+  labels:
+    app.kubernetes.io/name: front # the app
+    team: 'web'
+spec:
+  replicas: 3
+  template:
+    spec:
+      containers:
+        - name: frontend
+          image: "frontend:1.0"
+---
+kind: Deployment
+metadata:
+  name: backend
+  namespace: web
+  labels:
+    team: api
+`
+	expected := `# frontend
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: frontend
+  namespace: web
+  labels:
+    app.kubernetes.io/name: frontend # the app
+    team: 'web'
+    tier: "1"
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+        - name: frontend
+          image: "frontend:2.0"
+          imagePullPolicy: Always
+---
+kind: Deployment
+metadata:
+  name: backend
+  namespace: web
+  labels:
+    team: api
+`
+	violations := []*verifier.Violation{
+		fixViolation("metadata.labels.app.kubernetes.io/name", "frontend"),
+		fixViolation("metadata.labels.tier", "1"),
+		fixViolation("spec.replicas", 2),
+		fixViolation("spec.template.spec.containers[0].image", "frontend:2.0"),
+		fixViolation("spec.template.spec.containers[0].imagePullPolicy", "Always"),
+		{Key: "spec.replicas", Resource: testResource},
+	}
+	fixed, applied, errs := Apply([]byte(content), violations)
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	if applied != 5 {
+		t.Errorf("Expected 5 fixes to be applied, got %v", applied)
+	}
+	if string(fixed) != expected {
+		t.Errorf("Unexpected fixed content:\n%v", string(fixed))
+	}
+}
+
+func TestApplyJSON(t *testing.T) {
+	content := `{
+   "kind": "Deployment",
+   "metadata": {
+      "name": "frontend",
+      "namespace": "web",
+      "labels": {
+         "team": "api"
+      },
+      "annotations": {}
+   },
+   "spec": {"replicas": 3}
+}
+`
+	expected := `{
+   "kind": "Deployment",
+   "metadata": {
+      "name": "frontend",
+      "namespace": "web",
+      "labels": {
+         "app": "frontend",
+         "team": "web"
+      },
+      "annotations": {"owner": "web"}
+   },
+   "spec": {"replicas": 2}
+}
+`
+	violations := []*verifier.Violation{
+		fixViolation("metadata.labels.team", "web"),
+		fixViolation("metadata.labels.app", "frontend"),
+		fixViolation("metadata.annotations.owner", "web"),
+		fixViolation("spec.replicas", 2),
+	}
+	fixed, applied, errs := Apply([]byte(content), violations)
+	if len(errs) > 0 {
+		t.Errorf("Unexpected errors: %v", errs)
+	}
+	if applied != 4 {
+		t.Errorf("Expected 4 fixes to be applied, got %v", applied)
+	}
+	if string(fixed) != expected {
+		t.Errorf("Unexpected fixed content:\n%v", string(fixed))
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	content := `kind: Deployment
+metadata:
+  name: frontend
+  namespace: web
+  labels:
+    team: api
+`
+	other := &verifier.Violation{Resource: &verifier.ResourceIdentifier{Kind: "Service", Namespace: "web", Name: "frontend"}, Fix: &verifier.Fix{Key: "metadata.name", Value: "x"}}
+	violations := []*verifier.Violation{
+		other,
+		fixViolation("metadata.name.first", "x"),
+		fixViolation("metadata.labels.team", "web"),
+		fixViolation("metadata.labels.team", "mobile"),
+	}
+	fixed, applied, errs := Apply([]byte(content), violations)
+	if applied != 1 {
+		t.Errorf("Expected 1 fix to be applied, got %v", applied)
+	}
+	expectedErrs := []string{
+		"Could not fix metadata.name: Service web/frontend not found",
+		"Could not fix metadata.name.first of Deployment web/frontend: key first not found",
+		"Could not fix metadata.labels.team: it conflicts with another fix",
+	}
+	if len(errs) != len(expectedErrs) {
+		t.Fatalf("Expected %v errors, got %v", len(expectedErrs), errs)
+	}
+	for i, err := range errs {
+		if err.Error() != expectedErrs[i] {
+			t.Errorf("Expected error %q, got %q", expectedErrs[i], err.Error())
+		}
+	}
+	if !strings.Contains(string(fixed), "team: web\n") {
+		t.Errorf("Expected the first fix to be applied, got:\n%v", string(fixed))
+	}
+
+	if _, _, errs := Apply([]byte("a: [\n"), violations); len(errs) != 1 {
+		t.Errorf("Expected a parse error, got %v", errs)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	before := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+	after := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n"
+	expected := `--- a/x.yaml
++++ b/x.yaml
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -10,3 +10,4 @@
+ j
+ k
+ l
++m
+`
+	if diff := Diff("x.yaml", []byte(before), []byte(after)); diff != expected {
+		t.Errorf("Unexpected diff:\n%v", diff)
+	}
+	// Changes separated by two contexts share a hunk
+	after = "a\nB\nc\nd\ne\nf\ng\nh\nI\nj\nk\nl\n"
+	expected = `--- a/x.yaml
++++ b/x.yaml
+@@ -1,12 +1,12 @@
+ a
+-b
++B
+ c
+ d
+ e
+ f
+ g
+ h
+-i
++I
+ j
+ k
+ l
+`
+	if diff := Diff("x.yaml", []byte(before), []byte(after)); diff != expected {
+		t.Errorf("Unexpected diff:\n%v", diff)
+	}
+	if diff := Diff("x.yaml", []byte(before), []byte(before)); diff != "" {
+		t.Errorf("Expected no diff, got:\n%v", diff)
+	}
+}
@@ -0,0 +1,196 @@
+// gatekeeper.libsonnet defines the gatekeeper ruleset functions, import it with
+// local gatekeeper = import "gatekeeper.libsonnet";
+{
+  // LT() checks if the selected field is less than the given value, with coerce=true numeric strings are
+  // converted to numbers first
+  LT(value=0, coerce=false):: {
+    gatekeeper: true,
+    operation: "<",
+    value: value
+  } + (if coerce then { coerce: true } else {}),
+
+  // GT() checks if the selected field is greater than the given value, with coerce=true numeric strings are
+  // converted to numbers first
+  GT(value=0, coerce=false):: {
+    gatekeeper: true,
+    operation: ">",
+    value: value
+  } + (if coerce then { coerce: true } else {}),
+
+  // EQ() checks if the selected field is equal to the given value, with fix=true gatekeeper fix sets it to the value
+  EQ(value="", fix=false):: {
+    gatekeeper: true,
+    operation: "=",
+    value: value
+  } + (if fix then { fix: true } else {}),
+
+  // AND() checks if both op1 and op2 are satisfied
+  AND(op1, op2):: {
+    gatekeeper: true,
+    operation: "&",
+    op1: op1,
+    op2: op2,
+  },
+
+  // OR() checks if one of op1 or op2 is satisfied
+  OR(op1, op2):: {
+    gatekeeper: true,
+    operation: "|",
+    op1: op1,
+    op2: op2,
+  },
+
+  // NOT() checks if op is not satisfied
+  NOT(op):: {
+    gatekeeper: true,
+    operation: "!",
+    op: op,
+  },
+
+  // TAG() verifies that all TAG() with the same tag have the same value, with fix=true gatekeeper fix sets it to the first value
+  TAG(tag, fix=false):: {
+    gatekeeper: true,
+    operation: "tag",
+    tag: tag,
+  } + (if fix then { fix: true } else {}),
+
+  // PATH() checks if the selected field is equal to a component of the file path, with fix=true gatekeeper fix sets it to the component
+  PATH(index, fix=false):: {
+    gatekeeper: true,
+    operation: "path",
+    index: index,
+  } + (if fix then { fix: true } else {}),
+
+  // EVERY() checks if every element of the selected array satisfies tree
+  EVERY(tree):: {
+    gatekeeper: true,
+    operation: "every",
+    tree: tree,
+  },
+
+  // SOME() checks if at least one element of the selected array satisfies tree
+  SOME(tree):: {
+    gatekeeper: true,
+    operation: "some",
+    tree: tree,
+  },
+
+  // INDEX() checks if the element at index of the selected array satisfies tree
+  INDEX(index, tree):: {
+    gatekeeper: true,
+    operation: "index",
+    index: index,
+    tree: tree,
+  },
+
+  // REF() checks if the selected field is the name of a resource of kind in the same namespace
+  REF(kind):: {
+    gatekeeper: true,
+    operation: "ref",
+    kind: kind,
+  },
+
+  // SELECTS() checks if the selected label selector matches the pod template of a resource in the same namespace
+  SELECTS(kind=""):: {
+    gatekeeper: true,
+    operation: "selects",
+    kind: kind,
+  },
+
+  // MATCH() checks if the selected field matches the regex
+  MATCH(regex):: {
+    gatekeeper: true,
+    operation: "match",
+    regex: regex,
+  },
+
+  // GLOB() checks if the selected field matches the glob pattern, * does not match / but ** does
+  GLOB(pattern):: {
+    gatekeeper: true,
+    operation: "glob",
+    pattern: pattern,
+  },
+
+  // IN() checks if the selected field is equal to one of the given values
+  IN(values):: {
+    gatekeeper: true,
+    operation: "in",
+    values: values,
+  },
+
+  // NOTIN() checks if the selected field is not equal to any of the given values
+  NOTIN(values):: {
+    gatekeeper: true,
+    operation: "notin",
+    values: values,
+  },
+
+  // PREFIX() checks if the selected field starts with the given value
+  PREFIX(value):: {
+    gatekeeper: true,
+    operation: "prefix",
+    value: value,
+  },
+
+  // SUFFIX() checks if the selected field ends with the given value
+  SUFFIX(value):: {
+    gatekeeper: true,
+    operation: "suffix",
+    value: value,
+  },
+
+  // CONTAINS() checks if the selected string contains the given substring, or the selected array contains the given value
+  CONTAINS(value):: {
+    gatekeeper: true,
+    operation: "contains",
+    value: value,
+  },
+
+  // EXISTS() checks if the selected field is set
+  EXISTS():: {
+    gatekeeper: true,
+    operation: "exists",
+  },
+
+  // DEFAULT() checks if the selected field is set, gatekeeper fix sets missing fields to the value
+  DEFAULT(value):: {
+    gatekeeper: true,
+    operation: "default",
+    value: value,
+  },
+
+  // ABSENT() checks if the selected field is not set
+  ABSENT():: {
+    gatekeeper: true,
+    operation: "absent",
+  },
+
+  // OPTIONAL() checks if op is satisfied when the selected field is set, and passes when it is not
+  OPTIONAL(op):: {
+    gatekeeper: true,
+    operation: "optional",
+    op: op,
+  },
+
+  // QLT() checks if the selected Kubernetes quantity is less than value
+  QLT(value):: {
+    gatekeeper: true,
+    operation: "qlt",
+    value: value,
+  },
+
+  // QGT() checks if the selected Kubernetes quantity is greater than value
+  QGT(value):: {
+    gatekeeper: true,
+    operation: "qgt",
+    value: value,
+  },
+
+  // QRANGE() checks if the selected Kubernetes quantity is between min and max, inclusive
+  QRANGE(min, max):: {
+    gatekeeper: true,
+    operation: "qrange",
+    min: min,
+    max: max,
+  },
+}
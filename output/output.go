@@ -0,0 +1,283 @@
+package output
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/wish/gatekeeper/verifier"
+)
+
+// Formats lists the supported output formats
+var Formats = []string{"text", "json", "jsonl", "sarif", "junit"}
+
+// Write renders the violations to w in the given format
+func Write(w io.Writer, format string, violations []*verifier.Violation) error {
+	switch format {
+	case "", "text":
+		return writeText(w, violations)
+	case "json":
+		return writeJSON(w, violations)
+	case "jsonl":
+		return writeJSONLines(w, violations)
+	case "sarif":
+		return writeSARIF(w, violations)
+	case "junit":
+		return writeJUnit(w, violations)
+	default:
+		return fmt.Errorf("Unknown output format %v (must be one of %v)", format, Formats)
+	}
+}
+
+// Writes each violation as a numbered, human readable message
+func writeText(w io.Writer, violations []*verifier.Violation) error {
+	for i, v := range violations {
+		line := strconv.Itoa(i+1) + ". "
+		if v.Severity != "" && v.Severity != verifier.SeverityError {
+			line += "[" + v.Severity + "] "
+		}
+		if v.Suppressed {
+			line += "[suppressed by " + v.Suppression + "] "
+		}
+		line += v.Error()
+		if v.Docs != "" {
+			line += "\nSee: " + v.Docs
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Writes the violations as a single JSON array
+func writeJSON(w io.Writer, violations []*verifier.Violation) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "	")
+	return enc.Encode(violations)
+}
+
+// Writes one JSON object per line for each violation
+func writeJSONLines(w io.Writer, violations []*verifier.Violation) error {
+	enc := json.NewEncoder(w)
+	for _, v := range violations {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ruleID returns the identifier used to group a violation in SARIF and JUnit reports
+func ruleID(v *verifier.Violation) string {
+	if v.Rule != "" {
+		return v.Rule
+	}
+	if v.Function != "" {
+		return v.Function
+	}
+	return "structure"
+}
+
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string        `json:"id"`
+	ShortDescription *sarifMessage `json:"shortDescription,omitempty"`
+	HelpURI          string        `json:"helpUri,omitempty"`
+}
+
+type sarifResult struct {
+	RuleID       string                 `json:"ruleId"`
+	Level        string                 `json:"level"`
+	Message      sarifMessage           `json:"message"`
+	Locations    []sarifLocation        `json:"locations,omitempty"`
+	Suppressions []sarifSuppression     `json:"suppressions,omitempty"`
+	Properties   map[string]interface{} `json:"properties,omitempty"`
+}
+
+type sarifSuppression struct {
+	Kind          string `json:"kind"`
+	Justification string `json:"justification,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+// sarifLevels maps violation severities to SARIF result levels
+var sarifLevels = map[string]string{
+	verifier.SeverityError:   "error",
+	verifier.SeverityWarning: "warning",
+	verifier.SeverityInfo:    "note",
+	"":                       "error",
+}
+
+// Returns the SARIF kind of the suppression of a violation, the skip annotation is part of the resource's
+// source while exemptions and baselines are kept outside of it
+func sarifSuppressionKind(v *verifier.Violation) string {
+	if v.Suppression == verifier.SkipSuppression {
+		return "inSource"
+	}
+	return "external"
+}
+
+// Writes the violations as a SARIF 2.1.0 log, suppressed violations are results with a suppression
+func writeSARIF(w io.Writer, violations []*verifier.Violation) error {
+	run := sarifRun{
+		Tool: sarifTool{Driver: sarifDriver{
+			Name:           "gatekeeper",
+			InformationURI: "https://github.com/wish/gatekeeper",
+			Rules:          []sarifRule{},
+		}},
+		Results: []sarifResult{},
+	}
+	seen := map[string]bool{}
+	for _, v := range violations {
+		id := ruleID(v)
+		if !seen[id] {
+			seen[id] = true
+			rule := sarifRule{ID: id, HelpURI: v.Docs}
+			if v.Description != "" {
+				rule.ShortDescription = &sarifMessage{Text: v.Description}
+			}
+			run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, rule)
+		}
+		result := sarifResult{
+			RuleID:     id,
+			Level:      sarifLevels[v.Severity],
+			Message:    sarifMessage{Text: v.Error()},
+			Properties: v.Details,
+		}
+		if v.Suppressed {
+			result.Suppressions = []sarifSuppression{{Kind: sarifSuppressionKind(v), Justification: v.Suppression}}
+		}
+		if v.Path != "" {
+			result.Locations = []sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{URI: v.Path},
+			}}}
+		}
+		run.Results = append(run.Results, result)
+	}
+
+	log := sarifLog{
+		Version: "2.1.0",
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Runs:    []sarifRun{run},
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "	")
+	return enc.Encode(log)
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Skipped   *junitSkipped `xml:"skipped,omitempty"`
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Content string `xml:",chardata"`
+}
+
+// Writes the violations as a JUnit XML report with one failed test case per violation, suppressed violations
+// are skipped test cases
+func writeJUnit(w io.Writer, violations []*verifier.Violation) error {
+	suite := junitTestSuite{
+		Name:      "gatekeeper",
+		Tests:     len(violations),
+		TestCases: []junitTestCase{},
+	}
+	for _, v := range violations {
+		name := ruleID(v)
+		if v.Key != "" {
+			name = name + " " + v.Key
+		}
+		testCase := junitTestCase{Name: name, ClassName: v.Path}
+		if v.Suppressed {
+			suite.Skipped++
+			testCase.Skipped = &junitSkipped{Message: "Suppressed by " + v.Suppression}
+		} else {
+			suite.Failures++
+			testCase.Failure = &junitFailure{
+				Message: v.Message,
+				Type:    v.Severity,
+				Content: v.Error(),
+			}
+		}
+		suite.TestCases = append(suite.TestCases, testCase)
+	}
+
+	report := junitTestSuites{
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Suites:   []junitTestSuite{suite},
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "	")
+	if err := enc.Encode(report); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,141 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/wish/gatekeeper/verifier"
+)
+
+var testViolations = []*verifier.Violation{
+	verifier.NewGatekeeperError("Broken LT() rule: \n%v", map[string]interface{}{
+		"path":      "service/sample.json",
+		"key":       "spec.replicas",
+		"expected":  20,
+		"actual":    24,
+		"rule_type": "allow",
+	}).(*verifier.Violation),
+	verifier.ToViolation(bytes.ErrTooLarge),
+}
+
+func TestWriteText(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, "text", testViolations); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if !strings.HasPrefix(b.String(), "1. Broken LT() rule: \n{") || !strings.Contains(b.String(), "2. "+bytes.ErrTooLarge.Error()) {
+		t.Errorf("Unexpected text output: %v", b.String())
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, "json", testViolations); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	var result []map[string]interface{}
+	if err := json.Unmarshal(b.Bytes(), &result); err != nil {
+		t.Errorf("Could not unmarshal json output: %v", err)
+		return
+	}
+	if len(result) != 2 || result[0]["key"] != "spec.replicas" || result[0]["rule_type"] != "allow" || result[0]["message"] != "Broken LT() rule" {
+		t.Errorf("Unexpected json output: %v", b.String())
+	}
+}
+
+func TestWriteJSONLines(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, "jsonl", testViolations); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(b.String()), "\n"); len(lines) != 2 {
+		t.Errorf("Expected 2 lines, got %v", len(lines))
+	}
+}
+
+func TestWriteSARIF(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, "sarif", testViolations); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	var result sarifLog
+	if err := json.Unmarshal(b.Bytes(), &result); err != nil {
+		t.Errorf("Could not unmarshal sarif output: %v", err)
+		return
+	}
+	if result.Version != "2.1.0" || len(result.Runs) != 1 || len(result.Runs[0].Results) != 2 {
+		t.Errorf("Unexpected sarif output: %v", b.String())
+		return
+	}
+	if uri := result.Runs[0].Results[0].Locations[0].PhysicalLocation.ArtifactLocation.URI; uri != "service/sample.json" {
+		t.Errorf("Expected location service/sample.json, got %v", uri)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, "junit", testViolations); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	var result junitTestSuites
+	if err := xml.Unmarshal(b.Bytes(), &result); err != nil {
+		t.Errorf("Could not unmarshal junit output: %v", err)
+		return
+	}
+	if result.Failures != 2 || len(result.Suites[0].TestCases) != 2 || result.Suites[0].TestCases[0].ClassName != "service/sample.json" {
+		t.Errorf("Unexpected junit output: %v", b.String())
+	}
+}
+
+func TestWriteSuppressed(t *testing.T) {
+	skipped := *testViolations[0]
+	skipped.Suppressed = true
+	skipped.Suppression = verifier.SkipSuppression
+	baselined := *testViolations[0]
+	baselined.Suppressed = true
+	baselined.Suppression = verifier.BaselineSuppression
+	violations := []*verifier.Violation{testViolations[0], &skipped, &baselined}
+
+	var b bytes.Buffer
+	if err := Write(&b, "sarif", violations); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	var sarif sarifLog
+	if err := json.Unmarshal(b.Bytes(), &sarif); err != nil {
+		t.Errorf("Could not unmarshal sarif output: %v", err)
+		return
+	}
+	kinds := []string{}
+	for _, result := range sarif.Runs[0].Results {
+		for _, suppression := range result.Suppressions {
+			kinds = append(kinds, suppression.Kind)
+		}
+	}
+	if len(sarif.Runs[0].Results) != 3 || strings.Join(kinds, ",") != "inSource,external" {
+		t.Errorf("Expected an inSource and an external suppression, got %v", b.String())
+	}
+
+	b.Reset()
+	if err := Write(&b, "junit", violations); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	var junit junitTestSuites
+	if err := xml.Unmarshal(b.Bytes(), &junit); err != nil {
+		t.Errorf("Could not unmarshal junit output: %v", err)
+		return
+	}
+	testCases := junit.Suites[0].TestCases
+	if junit.Failures != 1 || junit.Skipped != 2 || testCases[1].Failure != nil || testCases[1].Skipped == nil {
+		t.Errorf("Expected suppressed violations to be skipped, got %v", b.String())
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	var b bytes.Buffer
+	if err := Write(&b, "yaml", testViolations); err == nil {
+		t.Errorf("Expected error for unknown output format")
+	}
+}
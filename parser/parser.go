@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -10,6 +11,7 @@ import (
 
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/client-go/kubernetes/scheme"
 )
@@ -39,69 +41,173 @@ func ParseNamespaces(path string) (map[string]bool, error) {
 	return ret, nil
 }
 
+// ParseObjectsFromFile reads the file at path and decodes its documents into Kubernetes objects
 func ParseObjectsFromFile(path string) ([]runtime.Object, error) {
+	fileContent, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseObjects(fileContent)
+}
+
+// ParseObjects decodes a multi-document JSON or YAML stream into Kubernetes objects
+func ParseObjects(content []byte) ([]runtime.Object, error) {
 	ret := []runtime.Object{}
 	decode := scheme.Codecs.UniversalDeserializer().Decode
 
-	fileContentArr, err := ioutil.ReadFile(path)
+	docs, err := SplitDocuments(content)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	fileContent := strings.TrimSuffix(strings.TrimSpace(string(fileContentArr)), "...")
-	f := NopReadCloser{bytes.NewBufferString(fileContent)}
-	defer f.Close()
-	d := yaml.NewDocumentDecoder(f)
-	out := []byte{}
-	prefix := []byte("---")
 
-	for {
-		b := make([]byte, 1)
-		_, err := d.Read(b)
-		out = append(out, b...)
-		if err == nil {
-			var jsonObj map[string]interface{}
-			if bytes.HasPrefix(out, prefix) {
-				err = json.Unmarshal(out[3:], &jsonObj)
-			} else {
-				err = json.Unmarshal(out, &jsonObj)
+	for _, doc := range docs {
+		var jsonObj map[string]interface{}
+		if err := json.Unmarshal(doc, &jsonObj); err != nil {
+			return nil, err
+		}
+		if kind, ok := jsonObj["kind"]; ok {
+			kindStr := fmt.Sprintf("%v", kind)
+			if kindStr == "CustomResourceDefinition" || kindStr == "APIService" {
+				// Should be able to pull these in and validate but I failed to get that working.
+				continue
 			}
-			if err != nil {
-				return nil, err
-
+		}
+		if apiVersion, ok := jsonObj["apiVersion"]; ok {
+			apiVersionStr := fmt.Sprintf("%v", apiVersion)
+			if apiVersionStr == "custom.k8s.io/v1" {
+				// can't parse any crd instances so just ignore.
+				continue
 			}
-			if kind, ok := jsonObj["kind"]; ok {
-				kindStr := kind.(string)
-				if kindStr == "CustomResourceDefinition" || kindStr == "APIService" {
-					// Should be able to pull these in and validate but I failed to get that working.
-					out = []byte{}
-					continue
-				}
+		}
 
-			}
-			if apiVersion, ok := jsonObj["apiVersion"]; ok {
-				apiVersionStr := apiVersion.(string)
-				if apiVersionStr == "custom.k8s.io/v1" {
-					// can't parse any crd instances so just ignore.
-					out = []byte{}
-					continue
-				}
-			}
+		obj, _, err := decode(doc, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, obj)
+	}
+	return ret, nil
+}
 
-			obj, _, err := decode(out, nil, nil)
-			if err != nil {
-				return nil, err
-			}
-			ret = append(ret, obj)
-			out = []byte{}
+// ParseResources decodes a multi-document JSON or YAML stream into generic resource maps
+func ParseResources(content []byte) ([]map[string]interface{}, error) {
+	ret := []map[string]interface{}{}
+	docs, err := SplitDocuments(content)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range docs {
+		var resource map[string]interface{}
+		if err := json.Unmarshal(doc, &resource); err != nil {
+			return nil, err
+		}
+		ret = append(ret, resource)
+	}
+	return ret, nil
+}
+
+// ParseFile splits and decodes a multi-document JSON or YAML stream once into generic resource maps, and checks
+// that each of them is a valid object of the built-in Kubernetes API. The resources are returned even if one of
+// them is not a valid object, the error then describes the first invalid one.
+func ParseFile(content []byte) ([]map[string]interface{}, error) {
+	docs, err := SplitDocuments(content)
+	if err != nil {
+		return nil, err
+	}
+	ret := []map[string]interface{}{}
+	var invalid error
+	for _, doc := range docs {
+		var resource map[string]interface{}
+		if err := json.Unmarshal(doc, &resource); err != nil {
+			return nil, err
 		}
+		ret = append(ret, resource)
+		if invalid == nil {
+			invalid = checkObject(doc, resource)
+		}
+	}
+	return ret, invalid
+}
+
+// checkObject checks that a decoded document converts to the Kubernetes object of its apiVersion and kind,
+// like ParseObjects decodes it, doc is only used in errors
+func checkObject(doc []byte, resource map[string]interface{}) error {
+	kind := fmt.Sprintf("%v", resource["kind"])
+	apiVersion := fmt.Sprintf("%v", resource["apiVersion"])
+	if kind == "CustomResourceDefinition" || kind == "APIService" || apiVersion == "custom.k8s.io/v1" {
+		// Custom resources and their definitions are not in the scheme, see ParseObjects
+		return nil
+	}
+	if _, ok := resource["kind"].(string); !ok || kind == "" {
+		return runtime.NewMissingKindErr(string(doc))
+	}
+	if _, ok := resource["apiVersion"].(string); !ok || apiVersion == "" {
+		return runtime.NewMissingVersionErr(string(doc))
+	}
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return err
+	}
+	obj, err := scheme.Scheme.New(gv.WithKind(kind))
+	if err != nil {
+		return err
+	}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(resource, obj); err != nil {
+		// Conversion errors do not name the field, decoding the invalid document again does
+		if jsonErr := json.Unmarshal(doc, obj); jsonErr != nil {
+			return jsonErr
+		}
+		return err
+	}
+	return nil
+}
+
+// SplitDocuments splits a multi-document JSON or YAML stream on its "---" and "..." markers
+// and converts each non-empty document to JSON. YAML anchors and aliases are resolved.
+func SplitDocuments(content []byte) ([][]byte, error) {
+	ret := [][]byte{}
+	r := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(content)))
+	for {
+		doc, err := r.Read()
 		if err == io.EOF {
-			d.Close()
 			break
 		}
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range splitDocumentEnd(doc) {
+			if len(bytes.TrimSpace(part)) == 0 {
+				continue
+			}
+			jsonDoc, err := yaml.ToJSON(part)
+			if err != nil {
+				return nil, err
+			}
+			// Documents containing only comments decode to null
+			if bytes.Equal(bytes.TrimSpace(jsonDoc), []byte("null")) {
+				continue
+			}
+			ret = append(ret, jsonDoc)
+		}
 	}
 	return ret, nil
 }
 
+// splitDocumentEnd splits a document on "..." document end markers
+func splitDocumentEnd(doc []byte) [][]byte {
+	ret := [][]byte{}
+	current := []byte{}
+	for _, line := range bytes.SplitAfter(doc, []byte("\n")) {
+		if strings.TrimSpace(string(line)) == "..." {
+			ret = append(ret, current)
+			current = []byte{}
+			continue
+		}
+		current = append(current, line...)
+	}
+	return append(ret, current)
+}
+
 type NopReadCloser struct {
 	io.Reader
 }
@@ -109,3 +215,13 @@ type NopReadCloser struct {
 func (NopReadCloser) Close() error {
 	return nil
 }
+
+// IsKnownKind returns whether kind is a kind of the built-in Kubernetes API
+func IsKnownKind(kind string) bool {
+	for gvk := range scheme.Scheme.AllKnownTypes() {
+		if gvk.Kind == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package render
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/cli/values"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/getter"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/yaml"
+
+	"github.com/wish/gatekeeper/parser"
+)
+
+// Kinds of directories that are rendered before they are verified
+const (
+	Helm      = "helm"
+	Kustomize = "kustomize"
+)
+
+// originAnnotation is the annotation kustomize records the file a resource comes from in
+const originAnnotation = "config.kubernetes.io/origin"
+
+// Options configure how charts are rendered
+type Options struct {
+	// ValuesFiles are merged in order over the values of the chart
+	ValuesFiles []string
+	// ReleaseName and Namespace are the name and namespace of the release templates are rendered for
+	ReleaseName string
+	Namespace   string
+}
+
+// Kind returns whether dir is a Helm chart, which has a Chart.yaml, or a kustomization, or "" if it is neither
+func Kind(dir string) string {
+	if fileExists(filepath.Join(dir, "Chart.yaml")) {
+		return Helm
+	}
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		if fileExists(filepath.Join(dir, name)) {
+			return Kustomize
+		}
+	}
+	return ""
+}
+
+// Render renders a Helm chart or kustomization directory in-process, and returns the rendered resources by
+// the path of the template or file they come from, so that rule regexes and PATH() match the source files
+func Render(dir string, options Options) (map[string][]map[string]interface{}, error) {
+	switch Kind(dir) {
+	case Helm:
+		return renderChart(dir, options)
+	case Kustomize:
+		if len(options.ValuesFiles) > 0 {
+			return nil, fmt.Errorf("Values files can only be used with Helm charts, %v is a kustomization", dir)
+		}
+		return renderKustomization(dir)
+	default:
+		return nil, fmt.Errorf("%v is not a Helm chart or a kustomization", dir)
+	}
+}
+
+// Renders the templates of a chart and its dependencies, resources are at the path of their template
+func renderChart(dir string, options Options) (map[string][]map[string]interface{}, error) {
+	chart, err := loader.Load(dir)
+	if err != nil {
+		return nil, fmt.Errorf("Could not load chart %v: %v", dir, err)
+	}
+	vals, err := (&values.Options{ValueFiles: options.ValuesFiles}).MergeValues(getter.Providers{})
+	if err != nil {
+		return nil, fmt.Errorf("Could not read values files: %v", err)
+	}
+	if err := chartutil.ProcessDependenciesWithMerge(chart, vals); err != nil {
+		return nil, fmt.Errorf("Could not process dependencies of chart %v: %v", dir, err)
+	}
+	releaseOptions := chartutil.ReleaseOptions{
+		Name:      options.ReleaseName,
+		Namespace: options.Namespace,
+		Revision:  1,
+		IsInstall: true,
+	}
+	renderValues, err := chartutil.ToRenderValues(chart, vals, releaseOptions, chartutil.DefaultCapabilities)
+	if err != nil {
+		return nil, fmt.Errorf("Could not render values of chart %v: %v", dir, err)
+	}
+	rendered, err := engine.Render(chart, renderValues)
+	if err != nil {
+		return nil, fmt.Errorf("Could not render chart %v: %v", dir, err)
+	}
+
+	names := make([]string, 0, len(rendered))
+	for name := range rendered {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := map[string][]map[string]interface{}{}
+	for _, name := range names {
+		ext := filepath.Ext(name)
+		if strings.TrimSpace(rendered[name]) == "" || (ext != ".yaml" && ext != ".yml" && ext != ".json") {
+			continue
+		}
+		// Templates are named after the chart, such as mychart/templates/deployment.yaml
+		parts := strings.SplitN(name, "/", 2)
+		path := filepath.ToSlash(filepath.Join(dir, parts[len(parts)-1]))
+		resources, err := parser.ParseResources([]byte(rendered[name]))
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse rendered template %v: %v", name, err)
+		}
+		files[path] = append(files[path], resources...)
+	}
+	return files, nil
+}
+
+// Builds a kustomization, resources are at the path of the file they are read from, or of the
+// kustomization that generates them
+func renderKustomization(dir string) (map[string][]map[string]interface{}, error) {
+	abs, err := filepath.Abs(dir)
+	if err == nil {
+		abs, err = filepath.EvalSymlinks(abs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not read kustomization %v: %v", dir, err)
+	}
+	fs := &originFileSystem{FileSystem: filesys.MakeFsOnDisk(), dir: abs}
+	resMap, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fs, abs)
+	if err != nil {
+		return nil, fmt.Errorf("Could not build kustomization %v: %v", dir, err)
+	}
+
+	files := map[string][]map[string]interface{}{}
+	for _, res := range resMap.Resources() {
+		path := filepath.ToSlash(filepath.Join(dir, fs.kustomization))
+		origin, err := res.GetOrigin()
+		if err == nil && origin != nil && origin.Repo == "" && origin.Path != "" {
+			path = filepath.ToSlash(filepath.Join(dir, origin.Path))
+		}
+		if fs.injected {
+			annotations := res.GetAnnotations()
+			delete(annotations, originAnnotation)
+			if err := res.SetAnnotations(annotations); err != nil {
+				return nil, err
+			}
+		}
+		resource, err := res.Map()
+		if err != nil {
+			return nil, fmt.Errorf("Could not read resource %v of kustomization %v: %v", res.OrgId(), dir, err)
+		}
+		files[path] = append(files[path], resource)
+	}
+	return files, nil
+}
+
+// originFileSystem reads the kustomization of dir with origin annotations in its build metadata, so that
+// kustomize records the file each resource comes from
+type originFileSystem struct {
+	filesys.FileSystem
+	dir string
+	// kustomization is the name of the kustomization file of dir once it is read
+	kustomization string
+	// injected is set if the kustomization did not already ask for origin annotations
+	injected bool
+}
+
+// ReadFile implements filesys.FileSystem
+func (fs *originFileSystem) ReadFile(path string) ([]byte, error) {
+	content, err := fs.FileSystem.ReadFile(path)
+	if err != nil || filepath.Dir(path) != fs.dir || !isKustomizationFile(filepath.Base(path)) {
+		return content, err
+	}
+	fs.kustomization = filepath.Base(path)
+
+	var kustomization map[string]interface{}
+	if err := yaml.Unmarshal(content, &kustomization); err != nil || kustomization == nil {
+		return content, nil
+	}
+	buildMetadata, _ := kustomization["buildMetadata"].([]interface{})
+	for _, option := range buildMetadata {
+		if option == types.OriginAnnotations {
+			return content, nil
+		}
+	}
+	kustomization["buildMetadata"] = append(buildMetadata, types.OriginAnnotations)
+	fs.injected = true
+	return yaml.Marshal(kustomization)
+}
+
+// Checks if a file name is a kustomization file name
+func isKustomizationFile(name string) bool {
+	for _, recognized := range konfig.RecognizedKustomizationFileNames() {
+		if name == recognized {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if a path exists and is not a directory
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && !info.IsDir()
+}
@@ -0,0 +1,84 @@
+package render
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+var chartTestDir = "test_files/chart"
+var kustomizeTestDir = "test_files/kustomize/overlay"
+
+// Returns the kind/namespace/name of the rendered resources by path
+func renderedIds(files map[string][]map[string]interface{}) map[string][]string {
+	ids := map[string][]string{}
+	for path, resources := range files {
+		for _, resource := range resources {
+			md := resource["metadata"].(map[string]interface{})
+			ids[path] = append(ids[path], resource["kind"].(string)+"/"+md["namespace"].(string)+"/"+md["name"].(string))
+		}
+		sort.Strings(ids[path])
+	}
+	return ids
+}
+
+func TestKind(t *testing.T) {
+	for dir, expected := range map[string]string{
+		chartTestDir:                 Helm,
+		kustomizeTestDir:             Kustomize,
+		"test_files/kustomize":       "",
+		"test_files/missing":         "",
+		chartTestDir + "/Chart.yaml": "",
+	} {
+		if kind := Kind(dir); kind != expected {
+			t.Errorf("Expected %v to be %q, got %q", dir, expected, kind)
+		}
+	}
+}
+
+func TestRenderChart(t *testing.T) {
+	files, err := Render(chartTestDir, Options{ReleaseName: "rel", Namespace: "web", ValuesFiles: []string{chartTestDir + "/values-prod.yaml"}})
+	if err != nil {
+		t.Fatalf("Error rendering chart: %v", err)
+	}
+	expected := map[string][]string{
+		chartTestDir + "/templates/deployment.yaml": {"Deployment/web/rel-web"},
+	}
+	if ids := renderedIds(files); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Expected resources %v, got %v", expected, ids)
+	}
+	deployment := files[chartTestDir+"/templates/deployment.yaml"][0]
+	if replicas := deployment["spec"].(map[string]interface{})["replicas"]; replicas != float64(20) {
+		t.Errorf("Expected the values file to set 20 replicas, got %v", replicas)
+	}
+
+	if _, err := Render(chartTestDir, Options{ValuesFiles: []string{"test_files/missing.yaml"}}); err == nil {
+		t.Errorf("Expected an error when a values file is missing")
+	}
+}
+
+func TestRenderKustomization(t *testing.T) {
+	files, err := Render(kustomizeTestDir, Options{})
+	if err != nil {
+		t.Fatalf("Error building kustomization: %v", err)
+	}
+	expected := map[string][]string{
+		"test_files/kustomize/base/deployment.yaml":       {"Deployment/prod/web"},
+		"test_files/kustomize/overlay/service.yaml":       {"Service/prod/web"},
+		"test_files/kustomize/overlay/kustomization.yaml": {"ConfigMap/prod/web-config-mgc92dd9c6"},
+	}
+	if ids := renderedIds(files); !reflect.DeepEqual(ids, expected) {
+		t.Errorf("Expected resources %v, got %v", expected, ids)
+	}
+	for path, resources := range files {
+		for _, resource := range resources {
+			if _, ok := resource["metadata"].(map[string]interface{})["annotations"]; ok {
+				t.Errorf("Expected the origin annotation of %v to be removed, got %v", path, resource["metadata"])
+			}
+		}
+	}
+
+	if _, err := Render(kustomizeTestDir, Options{ValuesFiles: []string{"values.yaml"}}); err == nil {
+		t.Errorf("Expected an error when using values files with a kustomization")
+	}
+}
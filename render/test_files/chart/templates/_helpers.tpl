@@ -0,0 +1,3 @@
+{{- define "web.labels" -}}
+team: {{ .Values.team }}
+{{- end -}}
@@ -0,0 +1,141 @@
+package verifier
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// BaselineSuppression is the suppression of violations that are recorded in a baseline
+const BaselineSuppression = "baseline"
+
+// Baseline records known violations, so that only new violations are reported
+type Baseline struct {
+	Entries []BaselineEntry `json:"entries"`
+}
+
+// BaselineEntry records the violations that share a fingerprint
+type BaselineEntry struct {
+	Fingerprint string `json:"fingerprint"`
+	Rule        string `json:"rule,omitempty"`
+	Message     string `json:"message"`
+	Kind        string `json:"kind,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Key         string `json:"key,omitempty"`
+	// Path is the file the violations were found in when the baseline was written, it is not part of the fingerprint
+	Path  string `json:"path,omitempty"`
+	Count int    `json:"count"`
+}
+
+// Fingerprint identifies a violation by its rule, the resource it was found in and its key, so that it
+// stays the same when files are moved or values change. Violations of unnamed rules are identified by
+// the ruleset file and position of their rule, violations not found by a rule by their message and
+// violations without a resource by their path.
+func (v *Violation) Fingerprint() string {
+	parts := []string{v.Rule}
+	if v.Rule == "" && v.rule != nil {
+		parts = []string{v.Source, strconv.Itoa(v.rule.index)}
+	} else if v.Rule == "" {
+		parts = []string{v.Message, v.RuleType}
+	}
+	if v.Resource != nil {
+		parts = append(parts, v.Resource.Kind, v.Resource.Namespace, v.Resource.Name)
+	} else {
+		parts = append(parts, v.Path)
+	}
+	parts = append(parts, v.Key)
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// NewBaseline creates a baseline of the unsuppressed violations of a report
+func NewBaseline(report Report) Baseline {
+	entries := map[string]*BaselineEntry{}
+	for _, v := range report.Unsuppressed() {
+		fingerprint := v.Fingerprint()
+		if entry, ok := entries[fingerprint]; ok {
+			entry.Count++
+			continue
+		}
+		entry := &BaselineEntry{
+			Fingerprint: fingerprint,
+			Rule:        v.Rule,
+			Message:     v.Message,
+			Key:         v.Key,
+			Path:        v.Path,
+			Count:       1,
+		}
+		if v.Resource != nil {
+			entry.Kind = v.Resource.Kind
+			entry.Namespace = v.Resource.Namespace
+			entry.Name = v.Resource.Name
+		}
+		entries[fingerprint] = entry
+	}
+
+	baseline := Baseline{Entries: make([]BaselineEntry, 0, len(entries))}
+	for _, entry := range entries {
+		baseline.Entries = append(baseline.Entries, *entry)
+	}
+	sort.Slice(baseline.Entries, func(i, j int) bool {
+		a, b := baseline.Entries[i], baseline.Entries[j]
+		if a.Path != b.Path {
+			return a.Path < b.Path
+		}
+		if a.Rule != b.Rule {
+			return a.Rule < b.Rule
+		}
+		return a.Fingerprint < b.Fingerprint
+	})
+	return baseline
+}
+
+// ReadBaseline reads a baseline written by Baseline.Write
+func ReadBaseline(r io.Reader) (Baseline, error) {
+	var baseline Baseline
+	if err := json.NewDecoder(r).Decode(&baseline); err != nil {
+		return Baseline{}, fmt.Errorf("Error reading baseline: %v", err)
+	}
+	return baseline, nil
+}
+
+// Write writes the baseline as indented JSON
+func (b Baseline) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "	")
+	return enc.Encode(b)
+}
+
+// Apply suppresses the violations of the report that are recorded in the baseline, up to the number of
+// times they were recorded. It returns the entries that no longer occur as many times, with the number of
+// violations that are fixed as their count.
+func (b Baseline) Apply(report Report) []BaselineEntry {
+	remaining := map[string]int{}
+	for _, entry := range b.Entries {
+		remaining[entry.Fingerprint] += entry.Count
+	}
+	for _, v := range report.Unsuppressed() {
+		fingerprint := v.Fingerprint()
+		if remaining[fingerprint] > 0 {
+			remaining[fingerprint]--
+			v.Suppressed = true
+			v.Suppression = BaselineSuppression
+		}
+	}
+
+	fixed := []BaselineEntry{}
+	for _, entry := range b.Entries {
+		if count := remaining[entry.Fingerprint]; count > 0 {
+			entry.Count = count
+			remaining[entry.Fingerprint] = 0
+			fixed = append(fixed, entry)
+		}
+	}
+	return fixed
+}
@@ -0,0 +1,487 @@
+package verifier
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/mitchellh/mapstructure"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// CompiledRuleSet is a ruleset whose rules are validated and compiled once, so that it can be
+// evaluated cheaply and concurrently. It must not be modified once it is compiled.
+type CompiledRuleSet struct {
+	RuleSet    RuleSet
+	rules      []*compiledRule
+	exemptions []*compiledExemption
+}
+
+// A rule with its regex, match and exclude blocks, message template, type and rule tree compiled
+type compiledRule struct {
+	Rule
+	regex   *regexp.Regexp
+	match   *compiledMatch
+	exclude *compiledMatch
+	// message is the parsed message template, or nil if the rule has no message
+	message *template.Template
+	allow   bool
+	tree    *objectNode
+	// index is the position of an unnamed rule among the unnamed rules of its source, it identifies the
+	// rule in baselines
+	index int
+	// references is set if the rule tree uses REF() or SELECTS(), which look up the other resources of
+	// the verified tree
+	references bool
+}
+
+// A node of a compiled rule tree, one of *objectNode, *arrayNode or *function. Other values of the
+// rule tree compile to nil and are not checked.
+type ruleNode interface{}
+
+// objectNode is an object of the rule tree, with its keys in order
+type objectNode struct {
+	keys     []string
+	children []ruleNode
+	// paths are the key paths of the children from the root of the resource, see expandKey
+	paths []string
+}
+
+// arrayNode is an array of the rule tree, its elements are matched positionally
+type arrayNode struct {
+	elements []ruleNode
+	// paths are the key paths of the elements from the root of the resource, see expandKey
+	paths []string
+}
+
+// function is a gatekeeper function of the rule tree
+type function struct {
+	operation string
+	name      string
+	// args is the decoded function, e.g. *LT for a LT() function
+	args interface{}
+	// operands are the functions of AND(), OR() and NOT()
+	operands []*function
+	// tree is the rule tree of OPTIONAL(), EVERY(), SOME() and INDEX()
+	tree ruleNode
+	// regex is the regex of MATCH(), or the pattern of GLOB() translated to a regex
+	regex *regexp.Regexp
+	// quantities are the quantities of QLT(), QGT() and QRANGE()
+	quantities []resource.Quantity
+}
+
+// Creates the struct each gatekeeper operation is decoded into
+var functionArgs = map[string]func() interface{}{
+	"&":        func() interface{} { return &AND{} },
+	"|":        func() interface{} { return &OR{} },
+	"!":        func() interface{} { return &NOT{} },
+	"<":        func() interface{} { return &LT{} },
+	">":        func() interface{} { return &GT{} },
+	"=":        func() interface{} { return &EQ{} },
+	"qlt":      func() interface{} { return &QLT{} },
+	"qgt":      func() interface{} { return &QGT{} },
+	"qrange":   func() interface{} { return &QRANGE{} },
+	"match":    func() interface{} { return &MATCH{} },
+	"glob":     func() interface{} { return &GLOB{} },
+	"in":       func() interface{} { return &IN{} },
+	"notin":    func() interface{} { return &NOTIN{} },
+	"prefix":   func() interface{} { return &PREFIX{} },
+	"suffix":   func() interface{} { return &SUFFIX{} },
+	"contains": func() interface{} { return &CONTAINS{} },
+	"exists":   func() interface{} { return &EXISTS{} },
+	"default":  func() interface{} { return &DEFAULT{} },
+	"absent":   func() interface{} { return &ABSENT{} },
+	"optional": func() interface{} { return &OPTIONAL{} },
+	"every":    func() interface{} { return &EVERY{} },
+	"some":     func() interface{} { return &SOME{} },
+	"index":    func() interface{} { return &INDEX{} },
+	"ref":      func() interface{} { return &REF{} },
+	"selects":  func() interface{} { return &SELECTS{} },
+	"tag":      func() interface{} { return &TAG{} },
+	"path":     func() interface{} { return &PATH{} },
+}
+
+// Compile validates and compiles the rules of a ruleset. Invalid rules are left out of the compiled
+// ruleset and reported once in the returned errors, along with invalid exemptions and reference checks.
+func Compile(ruleSet RuleSet) (*CompiledRuleSet, []error) {
+	exemptions, errs := compileExemptions(ruleSet.Exemptions)
+	errs = append(errs, validateReferences(ruleSet.References)...)
+	compiled := &CompiledRuleSet{RuleSet: ruleSet, exemptions: exemptions}
+	unnamed := map[string]int{}
+	for _, rule := range ruleSet.Rules {
+		c, ruleErrs := compileRule(rule)
+		if rule.Name == "" {
+			unnamed[rule.Source]++
+		}
+		if len(ruleErrs) > 0 {
+			errs = append(errs, ruleErrs...)
+			continue
+		}
+		c.index = unnamed[rule.Source] - 1
+		compiled.rules = append(compiled.rules, c)
+	}
+	return compiled, errs
+}
+
+// Compiles a rule, returns the errors that make it invalid
+func compileRule(rule Rule) (*compiledRule, []error) {
+	errs := []error{}
+	c := &compiledRule{Rule: rule}
+
+	reg, err := regexp.Compile(rule.Regex)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("Could not compile regex: %v", rule.Regex))
+	}
+	c.regex = reg
+
+	match, matchErrs := compileMatch(rule.Match, "match")
+	errs = append(errs, matchErrs...)
+	c.match = match
+	exclude, excludeErrs := compileMatch(rule.Exclude, "exclude")
+	errs = append(errs, excludeErrs...)
+	c.exclude = exclude
+
+	if rule.Message != "" {
+		tmpl, err := template.New("message").Option("missingkey=zero").Parse(rule.Message)
+		if err != nil {
+			errDetails := map[string]interface{}{
+				"message": rule.Message,
+				"error":   err.Error(),
+			}
+			errs = append(errs, NewGatekeeperError("Could not parse message template in rule: \n%v", errDetails))
+		}
+		c.message = tmpl
+	}
+
+	if rule.Type == "allow" {
+		c.allow = true
+	} else if rule.Type != "deny" {
+		errDetails := map[string]interface{}{
+			"type": rule.Type,
+		}
+		errs = append(errs, NewGatekeeperError("Invalid type field in rule (must be allow or deny): \n%v", errDetails))
+	}
+
+	if rule.Severity != "" && !ValidSeverity(rule.Severity) {
+		errDetails := map[string]interface{}{
+			"severity": rule.Severity,
+		}
+		errs = append(errs, NewGatekeeperError("Invalid severity field in rule (must be error, warning or info): \n%v", errDetails))
+	}
+
+	tree, treeErrs := compileObject(rule.RuleTree, "", rule.Coerce)
+	errs = append(errs, treeErrs...)
+	c.tree = tree
+	if len(treeErrs) == 0 {
+		referenced := map[string]bool{}
+		treeKinds(tree, referenced)
+		c.references = len(referenced) > 0
+	}
+
+	for i, err := range errs {
+		v := ToViolation(err)
+		v.Rule = rule.Name
+		v.Source = rule.Source
+		errs[i] = v
+	}
+	return c, errs
+}
+
+// Compiles a node of the rule tree at key
+func compileNode(node interface{}, key string, coerce bool) (ruleNode, []error) {
+	switch t := node.(type) {
+	case []interface{}:
+		errs := []error{}
+		array := &arrayNode{elements: make([]ruleNode, len(t)), paths: make([]string, len(t))}
+		for i, element := range t {
+			var elementErrs []error
+			array.paths[i] = indexKey(key, i)
+			array.elements[i], elementErrs = compileNode(element, array.paths[i], coerce)
+			errs = append(errs, elementErrs...)
+		}
+		return array, errs
+	case map[string]interface{}:
+		if _, ok := t["gatekeeper"]; ok {
+			return compileFunction(t, key, coerce)
+		}
+		return compileObject(t, key, coerce)
+	}
+	return nil, nil
+}
+
+// Compiles an object of the rule tree at key
+func compileObject(tree map[string]interface{}, key string, coerce bool) (*objectNode, []error) {
+	errs := []error{}
+	object := &objectNode{keys: make([]string, 0, len(tree))}
+	for k := range tree {
+		object.keys = append(object.keys, k)
+	}
+	sort.Strings(object.keys)
+
+	object.children = make([]ruleNode, len(object.keys))
+	object.paths = make([]string, len(object.keys))
+	for i, k := range object.keys {
+		object.paths[i] = k
+		if key != "" {
+			object.paths[i] = key + "." + k
+		}
+		var childErrs []error
+		object.children[i], childErrs = compileNode(tree[k], object.paths[i], coerce)
+		errs = append(errs, childErrs...)
+	}
+	return object, errs
+}
+
+// Compiles a gatekeeper function of the rule tree at key, numeric strings are converted to numbers if coerce is set
+func compileFunction(rule map[string]interface{}, key string, coerce bool) (*function, []error) {
+	operation := fmt.Sprintf("%v", rule["operation"])
+	newArgs, ok := functionArgs[operation]
+	if !ok {
+		return nil, []error{fmt.Errorf("Unknown gatekeeper operation encountered: %v", rule["operation"])}
+	}
+
+	f := &function{operation: operation, name: functionNames[operation], args: newArgs()}
+	if err := mapstructure.Decode(rule, f.args); err != nil {
+		errDetails := map[string]interface{}{
+			"key":   key,
+			"error": err.Error(),
+		}
+		return nil, []error{NewGatekeeperError("Invalid arguments of "+f.name+"() function: \n%v", errDetails)}
+	}
+
+	errs := []error{}
+	switch args := f.args.(type) {
+	case *AND:
+		errs = append(errs, f.compileOperands(key, coerce, args.Op1, args.Op2)...)
+	case *OR:
+		errs = append(errs, f.compileOperands(key, coerce, args.Op1, args.Op2)...)
+	case *NOT:
+		errs = append(errs, f.compileOperands(key, coerce, args.Op)...)
+	case *LT:
+		args.Coerce = args.Coerce || coerce
+	case *GT:
+		args.Coerce = args.Coerce || coerce
+	case *QLT:
+		errs = append(errs, f.compileQuantities(key, args.Value)...)
+	case *QGT:
+		errs = append(errs, f.compileQuantities(key, args.Value)...)
+	case *QRANGE:
+		errs = append(errs, f.compileQuantities(key, args.Min, args.Max)...)
+	case *MATCH:
+		reg, err := regexp.Compile(args.Regex)
+		if err != nil {
+			errDetails := map[string]interface{}{
+				"key":   key,
+				"regex": args.Regex,
+			}
+			errs = append(errs, NewGatekeeperError("Could not compile MATCH() regex: \n%v", errDetails))
+		}
+		f.regex = reg
+	case *GLOB:
+		reg, err := globRegex(args.Pattern)
+		if err != nil {
+			errDetails := map[string]interface{}{
+				"key":     key,
+				"pattern": args.Pattern,
+			}
+			errs = append(errs, NewGatekeeperError("Invalid GLOB() pattern: \n%v", errDetails))
+		}
+		f.regex = reg
+	case *OPTIONAL:
+		errs = append(errs, f.compileTree(args.Op, key, coerce)...)
+	case *EVERY:
+		errs = append(errs, f.compileTree(args.Tree, key+anyIndex, coerce)...)
+	case *SOME:
+		errs = append(errs, f.compileTree(args.Tree, key+anyIndex, coerce)...)
+	case *INDEX:
+		errs = append(errs, f.compileIndex(key, args.Index)...)
+		errs = append(errs, f.compileTree(args.Tree, indexKey(key, args.Index), coerce)...)
+	case *PATH:
+		errs = append(errs, f.compileIndex(key, args.Index)...)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return f, nil
+}
+
+// Translates a GLOB() pattern to an anchored regex. Patterns have the syntax of path.Match, where * does not
+// match /, and ** matches any sequence of characters including /.
+func globRegex(pattern string) (*regexp.Regexp, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	var b strings.Builder
+	b.WriteString("^")
+	runes := []rune(pattern)
+	for i := 0; i < len(runes); i++ {
+		switch r := runes[i]; r {
+		case '*':
+			if i+1 < len(runes) && runes[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '\\':
+			i++
+			b.WriteString(regexp.QuoteMeta(string(runes[i])))
+		case '[':
+			// Character classes have the same syntax, except that escaped characters must be quoted
+			b.WriteRune('[')
+			for i++; runes[i] != ']'; i++ {
+				if runes[i] == '\\' {
+					i++
+					b.WriteString(`\x{` + strconv.FormatInt(int64(runes[i]), 16) + `}`)
+				} else {
+					b.WriteRune(runes[i])
+				}
+			}
+			b.WriteRune(']')
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// Compiles the operands of AND(), OR() and NOT(), which must be functions
+func (f *function) compileOperands(key string, coerce bool, operands ...map[string]interface{}) []error {
+	errs := []error{}
+	for _, operand := range operands {
+		if _, ok := operand["gatekeeper"]; !ok {
+			errDetails := map[string]interface{}{
+				"key":     key,
+				"operand": operand,
+			}
+			errs = append(errs, NewGatekeeperError("Operand of "+f.name+"() is not a gatekeeper function: \n%v", errDetails))
+			continue
+		}
+		compiled, operandErrs := compileFunction(operand, key, coerce)
+		errs = append(errs, operandErrs...)
+		f.operands = append(f.operands, compiled)
+	}
+	return errs
+}
+
+// Compiles the rule tree of OPTIONAL(), EVERY(), SOME() and INDEX()
+func (f *function) compileTree(tree interface{}, key string, coerce bool) []error {
+	var errs []error
+	f.tree, errs = compileNode(tree, key, coerce)
+	return errs
+}
+
+// Checks the index of INDEX() and PATH(), which must not be negative
+func (f *function) compileIndex(key string, index int) []error {
+	if index < 0 {
+		errDetails := map[string]interface{}{
+			"key":   key,
+			"index": index,
+		}
+		return []error{NewGatekeeperError(f.name+"() index must not be negative: \n%v", errDetails)}
+	}
+	return nil
+}
+
+// Parses the quantities of QLT(), QGT() and QRANGE()
+func (f *function) compileQuantities(key string, values ...interface{}) []error {
+	for _, value := range values {
+		quantity, err := parseQuantity(value)
+		if err != nil {
+			errDetails := map[string]interface{}{
+				"key":      key,
+				"quantity": value,
+			}
+			return []error{NewGatekeeperError("Could not parse "+f.name+"() quantity: \n%v", errDetails)}
+		}
+		f.quantities = append(f.quantities, quantity)
+	}
+	return nil
+}
+
+// podKinds are the kinds whose pods are selected by services and SELECTS() without a kind
+var podKinds = []string{"Pod", "Deployment", "StatefulSet", "DaemonSet", "ReplicaSet", "ReplicationController", "Job", "CronJob"}
+
+// Kinds returns the sorted kinds of the resources the rules verify, and of the resources their namespace
+// selectors, REF() and SELECTS() functions and the reference checks of the ruleset refer to
+func (c *CompiledRuleSet) Kinds() []string {
+	kinds := map[string]bool{}
+	for _, rule := range c.rules {
+		kinds[rule.Kind] = true
+		if rule.Match != nil {
+			for _, kind := range rule.Match.Kinds {
+				kinds[kind] = true
+			}
+		}
+		// Namespace selectors match the labels of the Namespace resources
+		if (rule.Match != nil && rule.Match.NamespaceSelector != nil) || (rule.Exclude != nil && rule.Exclude.NamespaceSelector != nil) {
+			kinds["Namespace"] = true
+		}
+		treeKinds(rule.tree, kinds)
+	}
+	for _, check := range c.RuleSet.References {
+		if kind, ok := referenceKinds[check]; ok {
+			kinds[kind] = true
+		}
+		if check == ReferenceSelectors {
+			kinds["Service"] = true
+			for _, kind := range podKinds {
+				kinds[kind] = true
+			}
+		}
+	}
+	delete(kinds, "")
+
+	sorted := make([]string, 0, len(kinds))
+	for kind := range kinds {
+		sorted = append(sorted, kind)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// Adds the kinds referred to by the REF() and SELECTS() functions of a rule tree
+func treeKinds(node ruleNode, kinds map[string]bool) {
+	switch n := node.(type) {
+	case *objectNode:
+		for _, child := range n.children {
+			treeKinds(child, kinds)
+		}
+	case *arrayNode:
+		for _, element := range n.elements {
+			treeKinds(element, kinds)
+		}
+	case *function:
+		switch args := n.args.(type) {
+		case *REF:
+			kinds[args.Kind] = true
+		case *SELECTS:
+			if args.Kind == "" {
+				for _, kind := range podKinds {
+					kinds[kind] = true
+				}
+			}
+			kinds[args.Kind] = true
+		}
+		for _, operand := range n.operands {
+			treeKinds(operand, kinds)
+		}
+		treeKinds(n.tree, kinds)
+	}
+}
+
+// Checks if a function can be applied to a missing key
+func (f *function) checksPresence() bool {
+	switch f.operation {
+	case "exists", "default", "absent", "optional":
+		return true
+	}
+	return false
+}
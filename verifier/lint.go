@@ -0,0 +1,280 @@
+package verifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	"github.com/wish/gatekeeper/parser"
+)
+
+// LintRuleset reads a jsonnet ruleset from r and checks it without verifying any resources. An error is
+// only returned if the ruleset cannot be evaluated, the problems found are returned in the report.
+func LintRuleset(r io.Reader, gatekeeperFunctions string) (Report, error) {
+	return NewLoader(gatekeeperFunctions).LintRuleset(r)
+}
+
+// LintRulesets checks the ruleset files of the ruleset paths, and the ruleset they merge into. Findings
+// have the path of the ruleset file they were found in.
+func LintRulesets(paths []string, gatekeeperFunctions string) (Report, error) {
+	return NewLoader(gatekeeperFunctions).LintRulesets(paths)
+}
+
+// LintRuleset reads a jsonnet ruleset from r and checks it without verifying any resources
+func (l *Loader) LintRuleset(r io.Reader) (Report, error) {
+	ruleSet, errs, err := l.readLintRuleset("<cmdline>", r)
+	if err != nil {
+		return Report{}, err
+	}
+	errs = append(errs, Lint(ruleSet)...)
+	return NewReport(errs), nil
+}
+
+// LintRulesets checks the ruleset files of the ruleset paths, and the ruleset they merge into
+func (l *Loader) LintRulesets(paths []string) (Report, error) {
+	files, err := RulesetFiles(paths)
+	if err != nil {
+		return Report{}, err
+	}
+	errs := []error{}
+	ruleSets := make([]RuleSet, 0, len(files))
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return Report{}, fmt.Errorf("Error reading %v: %v", file, err)
+		}
+		ruleSet, fieldErrs, err := l.readLintRuleset(file, f)
+		f.Close()
+		if err != nil {
+			return Report{}, fmt.Errorf("Error parsing %v: %v", file, err)
+		}
+		for _, err := range fieldErrs {
+			v := ToViolation(err)
+			v.Path = file
+			errs = append(errs, v)
+		}
+		for i := range ruleSet.Rules {
+			ruleSet.Rules[i].Source = file
+		}
+		ruleSets = append(ruleSets, ruleSet)
+	}
+
+	for _, err := range Lint(MergeRuleSets(ruleSets...)) {
+		v := ToViolation(err)
+		if v.Path == "" {
+			v.Path = v.Source
+		}
+		errs = append(errs, v)
+	}
+	return NewReport(errs), nil
+}
+
+// Evaluates a jsonnet ruleset from r, returns it with its unknown fields
+func (l *Loader) readLintRuleset(filename string, r io.Reader) (RuleSet, []error, error) {
+	jsonResult, err := l.evaluate(filename, r)
+	if err != nil {
+		return RuleSet{}, nil, err
+	}
+
+	var ruleSet RuleSet
+	if err := json.Unmarshal([]byte(jsonResult), &ruleSet); err != nil {
+		return RuleSet{}, nil, fmt.Errorf("Error unmarshalling ruleset json: %v", err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal([]byte(jsonResult), &raw); err != nil {
+		return RuleSet{}, nil, fmt.Errorf("Error unmarshalling ruleset json: %v", err)
+	}
+	return ruleSet, lintFields(raw), nil
+}
+
+// Lint checks a ruleset for invalid rules, exemptions and reference checks, and warns about rules
+// that can never match a resource
+func Lint(ruleSet RuleSet) []error {
+	_, errs := Compile(ruleSet)
+
+	names := map[string]bool{}
+	for i, rule := range ruleSet.Rules {
+		ruleErrs := []error{}
+		errDetails := map[string]interface{}{
+			"rule": i,
+		}
+		if rule.Regex == "" && (rule.Match == nil || rule.Match.Path == "") {
+			ruleErrs = append(ruleErrs, NewGatekeeperError("Rule is missing the regex field: \n%v", errDetails))
+		} else if re, err := syntax.Parse(rule.Regex, syntax.Perl); err == nil && requiresSlash(re) {
+			errDetails := map[string]interface{}{
+				"rule":  i,
+				"regex": rule.Regex,
+			}
+			ruleErrs = append(ruleErrs, lintWarning("Rule regex never matches, it is matched against file names which do not contain /: \n%v", errDetails))
+		}
+		kinds := []string{}
+		if rule.Kind != "" {
+			kinds = append(kinds, rule.Kind)
+		}
+		if rule.Match != nil {
+			kinds = append(kinds, rule.Match.Kinds...)
+		}
+		if len(kinds) == 0 {
+			ruleErrs = append(ruleErrs, NewGatekeeperError("Rule is missing the kind field: \n%v", errDetails))
+		}
+		for _, kind := range kinds {
+			if !parser.IsKnownKind(kind) {
+				errDetails := map[string]interface{}{
+					"rule": i,
+					"kind": kind,
+				}
+				ruleErrs = append(ruleErrs, lintWarning("Rule kind is not a built-in Kubernetes kind: \n%v", errDetails))
+			}
+		}
+		if rule.Exclude != nil && reflect.DeepEqual(*rule.Exclude, Match{}) {
+			ruleErrs = append(ruleErrs, lintWarning("Rule exclude block is empty and excludes every resource: \n%v", errDetails))
+		}
+		if rule.Type == "allow" && len(rule.RuleTree) == 0 {
+			ruleErrs = append(ruleErrs, lintWarning("Allow rule has an empty ruleTree and never reports a violation: \n%v", errDetails))
+		}
+		if rule.Name != "" {
+			if names[rule.Name] {
+				ruleErrs = append(ruleErrs, lintWarning("Duplicate rule name: \n%v", errDetails))
+			}
+			names[rule.Name] = true
+		}
+		for _, err := range ruleErrs {
+			v := ToViolation(err)
+			v.Rule = rule.Name
+			v.Source = rule.Source
+			errs = append(errs, v)
+		}
+	}
+
+	for i, exemption := range ruleSet.Exemptions {
+		for _, name := range exemption.Rules {
+			if !names[name] {
+				errDetails := map[string]interface{}{
+					"exemption": i,
+					"rule":      name,
+				}
+				errs = append(errs, lintWarning("Exemption refers to a rule that does not exist: \n%v", errDetails))
+			}
+		}
+	}
+	return errs
+}
+
+// Creates a lint finding with warning severity
+func lintWarning(errString string, errDetails map[string]interface{}) error {
+	v := ToViolation(NewGatekeeperError(errString, errDetails))
+	v.Severity = SeverityWarning
+	return v
+}
+
+// Reports the fields of the ruleset, its rules and its exemptions that are not known, json
+// decoding ignores them so they are usually typos
+func lintFields(raw map[string]interface{}) []error {
+	errs := unknownFields(raw, RuleSet{}, map[string]interface{}{})
+	errs = append(errs, lintItemFields(raw, "rules", "rule", Rule{})...)
+	errs = append(errs, lintMatchFields(raw)...)
+	errs = append(errs, lintItemFields(raw, "exemptions", "exemption", Exemption{})...)
+	return errs
+}
+
+// Reports the unknown fields of each object in the list field of the ruleset
+func lintItemFields(raw map[string]interface{}, field string, detail string, known interface{}) []error {
+	errs := []error{}
+	for k, v := range raw {
+		if !strings.EqualFold(k, field) {
+			continue
+		}
+		items, _ := v.([]interface{})
+		for i, item := range items {
+			if object, ok := item.(map[string]interface{}); ok {
+				errs = append(errs, unknownFields(object, known, map[string]interface{}{detail: i})...)
+			}
+		}
+	}
+	return errs
+}
+
+// Reports the unknown fields of the match and exclude blocks of the rules of the ruleset
+func lintMatchFields(raw map[string]interface{}) []error {
+	errs := []error{}
+	for k, v := range raw {
+		if !strings.EqualFold(k, "rules") {
+			continue
+		}
+		rules, _ := v.([]interface{})
+		for i, rule := range rules {
+			object, _ := rule.(map[string]interface{})
+			fields := []string{}
+			for field := range object {
+				if strings.EqualFold(field, "match") || strings.EqualFold(field, "exclude") {
+					fields = append(fields, field)
+				}
+			}
+			sort.Strings(fields)
+			for _, field := range fields {
+				if block, ok := object[field].(map[string]interface{}); ok {
+					errs = append(errs, unknownFields(block, Match{}, map[string]interface{}{"rule": i, "block": field})...)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// Returns an error for each key of object that is not a field of the struct known, in sorted order
+func unknownFields(object map[string]interface{}, known interface{}, errDetails map[string]interface{}) []error {
+	t := reflect.TypeOf(known)
+	keys := []string{}
+	for k := range object {
+		field, ok := t.FieldByNameFunc(func(name string) bool { return strings.EqualFold(name, k) })
+		if !ok || field.Tag.Get("json") == "-" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	errs := []error{}
+	for _, k := range keys {
+		details := map[string]interface{}{"field": k}
+		for dk, dv := range errDetails {
+			details[dk] = dv
+		}
+		errs = append(errs, NewGatekeeperError("Unknown field in "+t.Name()+": \n%v", details))
+	}
+	return errs
+}
+
+// Checks if every string matched by a regex contains a /
+func requiresSlash(re *syntax.Regexp) bool {
+	switch re.Op {
+	case syntax.OpLiteral:
+		for _, r := range re.Rune {
+			if r == '/' {
+				return true
+			}
+		}
+	case syntax.OpConcat:
+		for _, sub := range re.Sub {
+			if requiresSlash(sub) {
+				return true
+			}
+		}
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiresSlash(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min > 0 && requiresSlash(re.Sub[0])
+	case syntax.OpAlternate:
+		for _, sub := range re.Sub {
+			if !requiresSlash(sub) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,237 @@
+package verifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/gobuffalo/packr"
+	jsonnet "github.com/google/go-jsonnet"
+	"github.com/google/go-jsonnet/ast"
+	"github.com/google/go-jsonnet/toolutils"
+)
+
+// libraryName is the import path of the packaged gatekeeper function library
+const libraryName = "gatekeeper.libsonnet"
+
+// builtinLibrary is the reserved import path of the packaged gatekeeper function library, which is resolved before
+// any file, so that the functions bound by the prelude cannot be replaced by a gatekeeper.libsonnet of the rulesets
+const builtinLibrary = "gatekeeper:" + libraryName
+
+// Loader evaluates jsonnet rulesets. Imports are resolved relative to the importing file, then in the
+// library paths, and "gatekeeper.libsonnet" falls back to the packaged function library, which is always
+// imported by "gatekeeper:gatekeeper.libsonnet".
+type Loader struct {
+	// Functions is the jsonnet prelude that binds the functions of each ruleset, usually GatekeeperFunctions()
+	Functions string
+	// LibraryPaths are searched in order for imports that are not found next to the importing file
+	LibraryPaths []string
+	// ExtVars are the external variables available to rulesets through std.extVar()
+	ExtVars map[string]string
+	// TLAVars are the top-level arguments of rulesets that are functions
+	TLAVars map[string]string
+}
+
+// NewLoader creates a loader that binds the gatekeeper functions in each ruleset
+func NewLoader(gatekeeperFunctions string) *Loader {
+	return &Loader{Functions: gatekeeperFunctions}
+}
+
+// GatekeeperFunctions returns the packaged jsonnet prelude that binds the ruleset functions, such as
+// LT(), as locals of a ruleset
+func GatekeeperFunctions() (string, error) {
+	box := packr.NewBox("../function_definitions")
+	gatekeeperFunctions, err := box.FindString("gatekeeper.jsonnet")
+	if err != nil {
+		return "", fmt.Errorf("Could not get gatekeeper.jsonnet from packr: %v", err)
+	}
+	return gatekeeperFunctions, nil
+}
+
+// GatekeeperLibrary returns the packaged jsonnet library of the ruleset functions, gatekeeper.libsonnet
+func GatekeeperLibrary() (string, error) {
+	box := packr.NewBox("../function_definitions")
+	library, err := box.FindString(libraryName)
+	if err != nil {
+		return "", fmt.Errorf("Could not get %v from packr: %v", libraryName, err)
+	}
+	return library, nil
+}
+
+// ParseRuleset parses the ruleset file and returns a RuleSet object
+func ParseRuleset(rulesetPath string, gatekeeperFunctions string) (RuleSet, error) {
+	return NewLoader(gatekeeperFunctions).ParseRuleset(rulesetPath)
+}
+
+// ReadRuleset reads a jsonnet ruleset from r and returns a RuleSet object
+func ReadRuleset(r io.Reader, gatekeeperFunctions string) (RuleSet, error) {
+	return NewLoader(gatekeeperFunctions).ReadRuleset(r)
+}
+
+// ParseRulesets parses the ruleset files of the ruleset paths and merges them in order
+func ParseRulesets(paths []string, gatekeeperFunctions string) (RuleSet, error) {
+	return NewLoader(gatekeeperFunctions).ParseRulesets(paths)
+}
+
+// ParseRuleset parses the ruleset file and returns a RuleSet object, its rules have the file as their source
+func (l *Loader) ParseRuleset(rulesetPath string) (RuleSet, error) {
+	f, err := os.Open(rulesetPath)
+	if err != nil {
+		return RuleSet{}, fmt.Errorf("Error reading %v: %v", rulesetPath, err)
+	}
+	defer f.Close()
+	ruleSet, err := l.readRuleset(rulesetPath, f)
+	if err != nil {
+		return RuleSet{}, fmt.Errorf("Error parsing %v: %v", rulesetPath, err)
+	}
+	for i := range ruleSet.Rules {
+		ruleSet.Rules[i].Source = rulesetPath
+	}
+	return ruleSet, nil
+}
+
+// ReadRuleset reads a jsonnet ruleset from r and returns a RuleSet object, imports are relative to the working directory
+func (l *Loader) ReadRuleset(r io.Reader) (RuleSet, error) {
+	return l.readRuleset("<cmdline>", r)
+}
+
+// ParseRulesets parses the ruleset files of the ruleset paths and merges them in order
+func (l *Loader) ParseRulesets(paths []string) (RuleSet, error) {
+	files, err := RulesetFiles(paths)
+	if err != nil {
+		return RuleSet{}, err
+	}
+	ruleSets := make([]RuleSet, 0, len(files))
+	for _, file := range files {
+		ruleSet, err := l.ParseRuleset(file)
+		if err != nil {
+			return RuleSet{}, err
+		}
+		ruleSets = append(ruleSets, ruleSet)
+	}
+	return MergeRuleSets(ruleSets...), nil
+}
+
+// Reads a jsonnet ruleset from r, filename is used for errors and relative imports
+func (l *Loader) readRuleset(filename string, r io.Reader) (RuleSet, error) {
+	jsonResult, err := l.evaluate(filename, r)
+	if err != nil {
+		return RuleSet{}, err
+	}
+
+	var ruleSet RuleSet
+	err = json.Unmarshal([]byte(jsonResult), &ruleSet)
+	if err != nil {
+		return RuleSet{}, fmt.Errorf("Error unmarshalling ruleset json: %v", err)
+	}
+	return ruleSet, nil
+}
+
+// Evaluates a jsonnet ruleset from r and returns the resulting json
+func (l *Loader) evaluate(filename string, r io.Reader) (string, error) {
+	ruleSetContent, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("Error reading ruleset: %v", err)
+	}
+
+	node, err := l.parse(filename, string(ruleSetContent))
+	if err != nil {
+		return "", fmt.Errorf("Error using go-jsonnet to parse ruleset: %v", err)
+	}
+	vm := l.vm()
+	jsonResult, err := vm.Evaluate(node)
+	if err != nil {
+		return "", fmt.Errorf("Error using go-jsonnet to parse ruleset: %v", vm.ErrorFormatter.Format(err))
+	}
+	return jsonResult, nil
+}
+
+// Parses a jsonnet ruleset with the functions of the loader bound. The prelude is parsed on the lines before the
+// ruleset, then the locations of the ruleset are moved back by these lines, so that errors have the lines and
+// columns of the ruleset.
+func (l *Loader) parse(filename string, content string) (ast.Node, error) {
+	if l.Functions == "" {
+		return jsonnet.SnippetToAST(filename, content)
+	}
+	offset := strings.Count(l.Functions, "\n") + 1
+	node, err := jsonnet.SnippetToAST(filename, l.Functions+"\n"+content)
+	if err != nil {
+		// Static errors start with their location
+		if staticErr, ok := err.(interface{ Loc() ast.LocationRange }); ok {
+			loc := staticErr.Loc()
+			if !loc.IsSet() {
+				return nil, err
+			}
+			msg := strings.TrimPrefix(err.Error(), loc.String()+" ")
+			loc.Begin.Line -= offset
+			loc.End.Line -= offset
+			return nil, fmt.Errorf("%v %v", loc.String(), msg)
+		}
+		return nil, err
+	}
+
+	source := node.Loc().File
+	visited := map[ast.Node]bool{}
+	var shift func(n ast.Node)
+	shift = func(n ast.Node) {
+		if n == nil || visited[n] {
+			return
+		}
+		visited[n] = true
+		if loc := n.Loc(); loc != nil && loc.File == source {
+			loc.Begin.Line -= offset
+			loc.End.Line -= offset
+		}
+		for _, child := range toolutils.Children(n) {
+			shift(child)
+		}
+	}
+	shift(node)
+	if source != nil && len(source.Lines) > offset {
+		source.Lines = source.Lines[offset:]
+	}
+	return node, nil
+}
+
+// Creates a jsonnet VM with the importer and variables of the loader
+func (l *Loader) vm() *jsonnet.VM {
+	vm := jsonnet.MakeVM()
+	vm.Importer(&libraryImporter{files: &jsonnet.FileImporter{JPaths: l.LibraryPaths}})
+	for key, val := range l.ExtVars {
+		vm.ExtVar(key, val)
+	}
+	for key, val := range l.TLAVars {
+		vm.TLAVar(key, val)
+	}
+	return vm
+}
+
+// libraryImporter imports the packaged gatekeeper.libsonnet at its reserved path, and files, falling back to the
+// packaged gatekeeper.libsonnet if it is not found as a file
+type libraryImporter struct {
+	files *jsonnet.FileImporter
+}
+
+// Import implements jsonnet.Importer
+func (i *libraryImporter) Import(importedFrom, importedPath string) (jsonnet.Contents, string, error) {
+	if importedPath == builtinLibrary {
+		return importLibrary()
+	}
+	contents, foundAt, err := i.files.Import(importedFrom, importedPath)
+	if err != nil && importedPath == libraryName {
+		return importLibrary()
+	}
+	return contents, foundAt, err
+}
+
+// Imports the packaged gatekeeper.libsonnet, it is found at its reserved path so that it is cached apart from files
+func importLibrary() (jsonnet.Contents, string, error) {
+	library, err := GatekeeperLibrary()
+	if err != nil {
+		return jsonnet.Contents{}, "", err
+	}
+	return jsonnet.MakeContents(library), builtinLibrary, nil
+}
@@ -0,0 +1,144 @@
+package verifier
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// A match or exclude block of a rule with its selectors and path regex compiled
+type compiledMatch struct {
+	*Match
+	namespaceSelector labels.Selector
+	labelSelector     labels.Selector
+	path              *regexp.Regexp
+}
+
+// Compiles the match or exclude block of a rule, field is the name of the block in errors
+func compileMatch(match *Match, field string) (*compiledMatch, []error) {
+	if match == nil {
+		return nil, nil
+	}
+	errs := []error{}
+	c := &compiledMatch{Match: match}
+	var err error
+	if c.namespaceSelector, err = compileSelector(match.NamespaceSelector); err != nil {
+		errDetails := map[string]interface{}{
+			"field": field + ".namespaceSelector",
+			"error": err.Error(),
+		}
+		errs = append(errs, NewGatekeeperError("Invalid label selector in rule: \n%v", errDetails))
+	}
+	if c.labelSelector, err = compileSelector(match.LabelSelector); err != nil {
+		errDetails := map[string]interface{}{
+			"field": field + ".labelSelector",
+			"error": err.Error(),
+		}
+		errs = append(errs, NewGatekeeperError("Invalid label selector in rule: \n%v", errDetails))
+	}
+	if match.Path != "" {
+		if c.path, err = regexp.Compile(match.Path); err != nil {
+			errDetails := map[string]interface{}{
+				"field": field + ".path",
+				"regex": match.Path,
+			}
+			errs = append(errs, NewGatekeeperError("Could not compile path regex in rule: \n%v", errDetails))
+		}
+	}
+	return c, errs
+}
+
+// Compiles a label selector, a nil selector matches everything
+func compileSelector(selector *metav1.LabelSelector) (labels.Selector, error) {
+	if selector == nil {
+		return labels.Everything(), nil
+	}
+	return metav1.LabelSelectorAsSelector(selector)
+}
+
+// Checks if the rule applies to a resource of a file: the resource must be of the kind of the rule or of its
+// match block, be matched by its match block and not be matched by its exclude block
+func (rule *compiledRule) applies(path string, resource map[string]interface{}, index *resourceIndex) bool {
+	kind := fmt.Sprintf("%v", resource["kind"])
+	if rule.Kind != kind && (rule.match == nil || !contains(rule.match.Kinds, kind)) {
+		return false
+	}
+	if rule.match != nil && !rule.match.matches(path, resource, index) {
+		return false
+	}
+	if rule.exclude != nil && (len(rule.exclude.Kinds) == 0 || contains(rule.exclude.Kinds, kind)) {
+		return !rule.exclude.matches(path, resource, index)
+	}
+	return true
+}
+
+// Checks if every field of the block but its kinds matches a resource of a file
+func (m *compiledMatch) matches(path string, resource map[string]interface{}, index *resourceIndex) bool {
+	apiVersion := fmt.Sprintf("%v", resource["apiVersion"])
+	if len(m.APIGroups) > 0 {
+		group := ""
+		if i := strings.Index(apiVersion, "/"); i >= 0 {
+			group = apiVersion[:i]
+		}
+		if !contains(m.APIGroups, group) && !contains(m.APIGroups, "*") {
+			return false
+		}
+	}
+	if len(m.APIVersions) > 0 && !contains(m.APIVersions, apiVersion) {
+		return false
+	}
+
+	// Namespace resources are matched by their name, like the resources they contain
+	namespace := resourceIdentifier(resource).Namespace
+	if resource["kind"] == "Namespace" {
+		namespace = lookupString(resource, "metadata", "name")
+	}
+	if len(m.Namespaces) > 0 && !contains(m.Namespaces, namespace) {
+		return false
+	}
+	if m.NamespaceSelector != nil {
+		namespaceLabels := index.namespaceLabels(namespace)
+		if resource["kind"] == "Namespace" {
+			namespaceLabels, _ = lookup(resource, "metadata", "labels").(map[string]interface{})
+		}
+		if !m.namespaceSelector.Matches(labelSet(namespaceLabels)) {
+			return false
+		}
+	}
+
+	metadataLabels, _ := lookup(resource, "metadata", "labels").(map[string]interface{})
+	if !m.labelSelector.Matches(labelSet(metadataLabels)) {
+		return false
+	}
+	annotations, _ := lookup(resource, "metadata", "annotations").(map[string]interface{})
+	for k, v := range m.Annotations {
+		annotation, ok := annotations[k]
+		if !ok || (v != "" && fmt.Sprintf("%v", annotation) != v) {
+			return false
+		}
+	}
+
+	return m.path == nil || m.path.MatchString(path)
+}
+
+// Converts the labels of a resource to a label set
+func labelSet(l map[string]interface{}) labels.Set {
+	set := labels.Set{}
+	for k, v := range l {
+		set[k] = fmt.Sprintf("%v", v)
+	}
+	return set
+}
+
+// Checks if a list of strings contains a string
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package verifier
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RulesetFiles expands ruleset paths into the ruleset files to load, in order. Directories expand to
+// the jsonnet files they contain, except test files, and globs expand to the paths they match.
+func RulesetFiles(paths []string) ([]string, error) {
+	files := []string{}
+	seen := map[string]bool{}
+	add := func(file string) {
+		if !seen[file] {
+			seen[file] = true
+			files = append(files, file)
+		}
+	}
+
+	for _, path := range paths {
+		matches := []string{path}
+		if strings.ContainsAny(path, "*?[") {
+			var err error
+			matches, err = filepath.Glob(path)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid ruleset glob %v: %v", path, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("No ruleset files match %v", path)
+			}
+		}
+
+		for _, match := range matches {
+			info, err := os.Stat(match)
+			if err != nil {
+				return nil, fmt.Errorf("Error reading %v: %v", match, err)
+			}
+			if !info.IsDir() {
+				add(match)
+				continue
+			}
+			entries, err := ioutil.ReadDir(match)
+			if err != nil {
+				return nil, fmt.Errorf("Error reading %v: %v", match, err)
+			}
+			for _, entry := range entries {
+				name := entry.Name()
+				if !entry.IsDir() && strings.HasSuffix(name, ".jsonnet") && !strings.HasSuffix(name, testFileSuffix) {
+					add(filepath.Join(match, name))
+				}
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No ruleset files found in %v", strings.Join(paths, ", "))
+	}
+	return files, nil
+}
+
+// MergeRuleSets merges rulesets in order, so that later rulesets can overlay earlier ones. Ignore lists,
+// exemptions and reference checks are combined and rules are concatenated. A rule named like a rule of an
+// earlier ruleset overrides it in place, and the names in Disable remove the rules of earlier rulesets.
+func MergeRuleSets(ruleSets ...RuleSet) RuleSet {
+	merged := RuleSet{}
+	references := map[string]bool{}
+	for _, ruleSet := range ruleSets {
+		merged.Ignore = append(merged.Ignore, ruleSet.Ignore...)
+		merged.Exemptions = append(merged.Exemptions, ruleSet.Exemptions...)
+		for _, reference := range ruleSet.References {
+			if !references[reference] {
+				references[reference] = true
+				merged.References = append(merged.References, reference)
+			}
+		}
+
+		disabled := map[string]bool{}
+		for _, name := range ruleSet.Disable {
+			disabled[name] = true
+		}
+		merged.Disable = append(merged.Disable, ruleSet.Disable...)
+		rules := []Rule{}
+		for _, rule := range merged.Rules {
+			if !disabled[rule.Name] {
+				rules = append(rules, rule)
+			}
+		}
+
+		// Rules of earlier rulesets by name, rules of the same ruleset do not override each other
+		earlier := map[string]int{}
+		for i, rule := range rules {
+			if rule.Name != "" {
+				earlier[rule.Name] = i
+			}
+		}
+		for _, rule := range ruleSet.Rules {
+			if i, ok := earlier[rule.Name]; ok && rule.Name != "" {
+				rules[i] = rule
+				continue
+			}
+			rules = append(rules, rule)
+		}
+		merged.Rules = rules
+	}
+	return merged
+}
@@ -0,0 +1,308 @@
+package verifier
+
+import (
+	"fmt"
+)
+
+// Built-in reference checks that can be enabled with the references field of a ruleset
+const (
+	ReferenceSelectors              = "selectors"
+	ReferenceSecrets                = "secrets"
+	ReferenceConfigMaps             = "configMaps"
+	ReferenceServiceAccounts        = "serviceAccounts"
+	ReferencePersistentVolumeClaims = "persistentVolumeClaims"
+)
+
+// referenceKinds maps the reference checks of named resources to the kind they reference
+var referenceKinds = map[string]string{
+	ReferenceSecrets:                "Secret",
+	ReferenceConfigMaps:             "ConfigMap",
+	ReferenceServiceAccounts:        "ServiceAccount",
+	ReferencePersistentVolumeClaims: "PersistentVolumeClaim",
+}
+
+// resourceIndex indexes every resource of the verified tree by namespace
+type resourceIndex struct {
+	namespaces map[string]*namespaceScope
+	// namespaceObjects are the Namespace resources of the tree by name, the first of duplicates is kept
+	namespaceObjects map[string]map[string]interface{}
+}
+
+// namespaceScope holds the resources of a single namespace
+type namespaceScope struct {
+	namespace string
+	names     map[string]map[string]bool
+	resources []map[string]interface{}
+}
+
+// reference is a reference from a resource to another resource in its namespace
+type reference struct {
+	key      string
+	kind     string
+	name     string
+	optional bool
+}
+
+// Creates an index of the given resources
+func newResourceIndex(resources []map[string]interface{}) *resourceIndex {
+	index := &resourceIndex{namespaces: make(map[string]*namespaceScope), namespaceObjects: make(map[string]map[string]interface{})}
+	for _, resource := range resources {
+		index.add(resource)
+	}
+	return index
+}
+
+// Adds a resource to the index
+func (index *resourceIndex) add(resource map[string]interface{}) {
+	if resource == nil {
+		return
+	}
+	id := resourceIdentifier(resource)
+	scope := index.scope(id.Namespace)
+	if _, ok := index.namespaces[id.Namespace]; !ok {
+		index.namespaces[id.Namespace] = scope
+	}
+	if _, ok := scope.names[id.Kind]; !ok {
+		scope.names[id.Kind] = make(map[string]bool)
+	}
+	scope.names[id.Kind][id.Name] = true
+	scope.resources = append(scope.resources, resource)
+	if _, ok := index.namespaceObjects[id.Name]; id.Kind == "Namespace" && !ok {
+		index.namespaceObjects[id.Name] = resource
+	}
+}
+
+// Returns the labels of the Namespace resource of a namespace, or nil if there is no index or no such resource
+func (index *resourceIndex) namespaceLabels(namespace string) map[string]interface{} {
+	if index == nil {
+		return nil
+	}
+	labels, _ := lookup(index.namespaceObjects[namespace], "metadata", "labels").(map[string]interface{})
+	return labels
+}
+
+// Returns the resources of a namespace, or nil if there is no index
+func (index *resourceIndex) scope(namespace string) *namespaceScope {
+	if index == nil {
+		return nil
+	}
+	if scope, ok := index.namespaces[namespace]; ok {
+		return scope
+	}
+	return &namespaceScope{namespace: namespace, names: make(map[string]map[string]bool)}
+}
+
+// Checks if a resource of the kind and name exists in the namespace
+func (scope *namespaceScope) exists(kind string, name string) bool {
+	if scope == nil {
+		return false
+	}
+	return scope.names[kind][name]
+}
+
+// Checks if the label selector matches the pod template labels of some resource in the namespace,
+// only resources of the given kind are considered if kind is not empty
+func (scope *namespaceScope) selects(kind string, selector map[string]interface{}) bool {
+	if scope == nil || len(selector) == 0 {
+		return false
+	}
+	for _, resource := range scope.resources {
+		if kind != "" && resource["kind"] != kind {
+			continue
+		}
+		labels, ok := podTemplateLabels(resource)
+		if !ok {
+			continue
+		}
+		matches := true
+		for k, v := range selector {
+			if label, ok := labels[k]; !ok || fmt.Sprintf("%v", label) != fmt.Sprintf("%v", v) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the labels of the pods a resource creates
+func podTemplateLabels(resource map[string]interface{}) (map[string]interface{}, bool) {
+	var labels interface{}
+	switch resource["kind"] {
+	case "Pod":
+		labels = lookup(resource, "metadata", "labels")
+	case "CronJob":
+		labels = lookup(resource, "spec", "jobTemplate", "spec", "template", "metadata", "labels")
+	default:
+		labels = lookup(resource, "spec", "template", "metadata", "labels")
+	}
+	l, ok := labels.(map[string]interface{})
+	return l, ok
+}
+
+// Returns the pod spec of a resource and its key
+func podSpec(resource map[string]interface{}) (map[string]interface{}, string) {
+	var spec interface{}
+	var key string
+	switch resource["kind"] {
+	case "Pod":
+		spec, key = lookup(resource, "spec"), "spec"
+	case "CronJob":
+		spec, key = lookup(resource, "spec", "jobTemplate", "spec", "template", "spec"), "spec.jobTemplate.spec.template.spec"
+	default:
+		spec, key = lookup(resource, "spec", "template", "spec"), "spec.template.spec"
+	}
+	s, ok := spec.(map[string]interface{})
+	if !ok {
+		return nil, ""
+	}
+	return s, key
+}
+
+// Returns the value at the given keys of a tree, or nil if it does not exist
+func lookup(tree interface{}, keys ...string) interface{} {
+	for _, k := range keys {
+		m, ok := tree.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		tree = m[k]
+	}
+	return tree
+}
+
+// Returns the value at the given keys as a string, or "" if it is not a string
+func lookupString(tree interface{}, keys ...string) string {
+	s, _ := lookup(tree, keys...).(string)
+	return s
+}
+
+// Returns the value at the given keys as a slice, or nil if it is not a slice
+func lookupSlice(tree interface{}, keys ...string) []interface{} {
+	s, _ := lookup(tree, keys...).([]interface{})
+	return s
+}
+
+// Lists the secrets, configMaps, serviceAccounts and persistentVolumeClaims referenced by a pod spec
+func podReferences(spec map[string]interface{}, key string) []reference {
+	refs := []reference{}
+	if name := lookupString(spec, "serviceAccountName"); name != "" && name != "default" {
+		refs = append(refs, reference{key + ".serviceAccountName", "ServiceAccount", name, false})
+	}
+	for i, secret := range lookupSlice(spec, "imagePullSecrets") {
+		if name := lookupString(secret, "name"); name != "" {
+			refs = append(refs, reference{indexKey(key+".imagePullSecrets", i) + ".name", "Secret", name, false})
+		}
+	}
+	for i, volume := range lookupSlice(spec, "volumes") {
+		volumeKey := indexKey(key+".volumes", i)
+		if name := lookupString(volume, "secret", "secretName"); name != "" {
+			refs = append(refs, reference{volumeKey + ".secret.secretName", "Secret", name, lookup(volume, "secret", "optional") == true})
+		}
+		if name := lookupString(volume, "configMap", "name"); name != "" {
+			refs = append(refs, reference{volumeKey + ".configMap.name", "ConfigMap", name, lookup(volume, "configMap", "optional") == true})
+		}
+		if name := lookupString(volume, "persistentVolumeClaim", "claimName"); name != "" {
+			refs = append(refs, reference{volumeKey + ".persistentVolumeClaim.claimName", "PersistentVolumeClaim", name, false})
+		}
+	}
+	for _, containers := range []string{"initContainers", "containers"} {
+		for i, container := range lookupSlice(spec, containers) {
+			containerKey := indexKey(key+"."+containers, i)
+			for j, env := range lookupSlice(container, "env") {
+				envKey := indexKey(containerKey+".env", j)
+				if name := lookupString(env, "valueFrom", "secretKeyRef", "name"); name != "" {
+					refs = append(refs, reference{envKey + ".valueFrom.secretKeyRef.name", "Secret", name, lookup(env, "valueFrom", "secretKeyRef", "optional") == true})
+				}
+				if name := lookupString(env, "valueFrom", "configMapKeyRef", "name"); name != "" {
+					refs = append(refs, reference{envKey + ".valueFrom.configMapKeyRef.name", "ConfigMap", name, lookup(env, "valueFrom", "configMapKeyRef", "optional") == true})
+				}
+			}
+			for j, envFrom := range lookupSlice(container, "envFrom") {
+				envFromKey := indexKey(containerKey+".envFrom", j)
+				if name := lookupString(envFrom, "secretRef", "name"); name != "" {
+					refs = append(refs, reference{envFromKey + ".secretRef.name", "Secret", name, lookup(envFrom, "secretRef", "optional") == true})
+				}
+				if name := lookupString(envFrom, "configMapRef", "name"); name != "" {
+					refs = append(refs, reference{envFromKey + ".configMapRef.name", "ConfigMap", name, lookup(envFrom, "configMapRef", "optional") == true})
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// Checks the references field of a ruleset for unknown reference checks
+func validateReferences(checks []string) []error {
+	errs := []error{}
+	for _, check := range checks {
+		if _, ok := referenceKinds[check]; !ok && check != ReferenceSelectors {
+			errDetails := map[string]interface{}{
+				"reference": check,
+			}
+			errs = append(errs, NewGatekeeperError("Unknown reference check (must be selectors, secrets, configMaps, serviceAccounts or persistentVolumeClaims): \n%v", errDetails))
+		}
+	}
+	return errs
+}
+
+// verifyReferences verifies that the resources of a file only reference resources that exist in their namespace
+func verifyReferences(path string, resources []map[string]interface{}, index *resourceIndex, checks []string) []error {
+	errs := []error{}
+	if len(checks) == 0 {
+		return errs
+	}
+	enabled := make(map[string]bool)
+	for _, check := range checks {
+		enabled[check] = true
+	}
+
+	for _, resource := range resources {
+		if resource == nil {
+			continue
+		}
+		id := resourceIdentifier(resource)
+		scope := index.scope(id.Namespace)
+
+		// Check services select some pods
+		if enabled[ReferenceSelectors] && id.Kind == "Service" {
+			if selector, ok := lookup(resource, "spec", "selector").(map[string]interface{}); ok && len(selector) > 0 && !scope.selects("", selector) {
+				errDetails := map[string]interface{}{
+					"path":      path,
+					"key":       "spec.selector",
+					"name":      id.Name,
+					"namespace": id.Namespace,
+					"selector":  selector,
+				}
+				errs = append(errs, identifyViolations(id, []error{NewGatekeeperError("Service selector does not match any pod template in its namespace: \n%v", errDetails)})...)
+			}
+		}
+
+		// Check pods only reference existing resources
+		spec, key := podSpec(resource)
+		if spec == nil {
+			continue
+		}
+		for _, ref := range podReferences(spec, key) {
+			if ref.optional || scope.exists(ref.kind, ref.name) {
+				continue
+			}
+			for check, kind := range referenceKinds {
+				if kind == ref.kind && enabled[check] {
+					errDetails := map[string]interface{}{
+						"path":      path,
+						"key":       ref.key,
+						"kind":      ref.kind,
+						"name":      ref.name,
+						"namespace": id.Namespace,
+					}
+					errs = append(errs, identifyViolations(id, []error{NewGatekeeperError("Referenced resource does not exist in the namespace: \n%v", errDetails)})...)
+				}
+			}
+		}
+	}
+	return errs
+}
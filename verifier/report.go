@@ -0,0 +1,41 @@
+package verifier
+
+// Report is the result of verifying Kubernetes resources against a ruleset
+type Report struct {
+	Violations []*Violation
+}
+
+// NewReport creates a report from the errors returned while verifying resources
+func NewReport(errs []error) Report {
+	violations := make([]*Violation, 0, len(errs))
+	for _, err := range errs {
+		violations = append(violations, ToViolation(err))
+	}
+	return Report{Violations: violations}
+}
+
+// Unsuppressed returns the violations that are not suppressed by annotations or exemptions
+func (r Report) Unsuppressed() []*Violation {
+	violations := []*Violation{}
+	for _, v := range r.Violations {
+		if !v.Suppressed {
+			violations = append(violations, v)
+		}
+	}
+	return violations
+}
+
+// Suppressed returns the number of violations suppressed by annotations or exemptions
+func (r Report) Suppressed() int {
+	return len(r.Violations) - len(r.Unsuppressed())
+}
+
+// Fails returns whether any unsuppressed violation is at least as severe as the given severity
+func (r Report) Fails(severity string) bool {
+	for _, v := range r.Unsuppressed() {
+		if v.Fails(severity) {
+			return true
+		}
+	}
+	return false
+}
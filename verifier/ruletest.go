@@ -0,0 +1,183 @@
+package verifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/wish/gatekeeper/parser"
+)
+
+// testFileSuffix is the suffix of the jsonnet files holding the test cases of a ruleset
+const testFileSuffix = "_test.jsonnet"
+
+// TestCase is a unit test of the rules of a ruleset: resources and the violations each named rule
+// is expected to report for them
+type TestCase struct {
+	Name string
+	// Path is the path the resources are verified at, it is matched by rule regexes and PATH()
+	Path string
+	// File is a manifest to read the resources from, relative to the test file. Path defaults to File as it is
+	// written, not to its path from the root of the rulesets, so rules that use PATH() need Path unless the
+	// test file is at that root.
+	File      string
+	Resources []map[string]interface{}
+	// Expect lists the messages of the violations expected from each named rule, an empty list expects none.
+	// Rules that are not listed are not checked.
+	Expect map[string][]string
+}
+
+// TestResult is the outcome of a test case
+type TestResult struct {
+	File string
+	Name string
+	// Diffs describe the violations that were missing (-) or unexpected (+) for each rule
+	Diffs []string
+	// Err is set if the test case could not be run
+	Err error
+}
+
+// Passed returns whether the test case ran and reported the expected violations
+func (r TestResult) Passed() bool {
+	return r.Err == nil && len(r.Diffs) == 0
+}
+
+// FindTests returns the test files in and below dir, which are named *_test.jsonnet
+func FindTests(dir string) ([]string, error) {
+	files := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.HasSuffix(info.Name(), testFileSuffix) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Error while finding tests: %v", err)
+	}
+	return files, nil
+}
+
+// ReadTests evaluates a jsonnet test file, which must be a list of test cases
+func ReadTests(path string) ([]TestCase, error) {
+	return (&Loader{}).ReadTests(path)
+}
+
+// ReadTests evaluates a jsonnet test file with the library paths and variables of the loader, but without
+// its functions, test files can import gatekeeper.libsonnet instead
+func (l *Loader) ReadTests(path string) ([]TestCase, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading %v: %v", path, err)
+	}
+	jsonResult, err := l.vm().EvaluateSnippet(path, string(content))
+	if err != nil {
+		return nil, fmt.Errorf("Error using go-jsonnet to parse %v: %v", path, err)
+	}
+	var testCases []TestCase
+	if err := json.Unmarshal([]byte(jsonResult), &testCases); err != nil {
+		return nil, fmt.Errorf("Error unmarshalling test cases of %v: %v", path, err)
+	}
+	return testCases, nil
+}
+
+// RunTests runs the test cases of a test file against the ruleset
+func (c *CompiledRuleSet) RunTests(file string, testCases []TestCase) []TestResult {
+	results := make([]TestResult, 0, len(testCases))
+	for i, testCase := range testCases {
+		result := TestResult{File: file, Name: testCase.Name}
+		if result.Name == "" {
+			result.Name = fmt.Sprintf("test %v", i)
+		}
+		result.Diffs, result.Err = c.runTest(filepath.Dir(file), testCase)
+		results = append(results, result)
+	}
+	return results
+}
+
+// Runs a test case, reading its file relative to dir, and returns the diffs of each rule that
+// did not report the expected violations
+func (c *CompiledRuleSet) runTest(dir string, testCase TestCase) ([]string, error) {
+	path := testCase.Path
+	resources := testCase.Resources
+	if testCase.File != "" {
+		content, err := ioutil.ReadFile(filepath.Join(dir, testCase.File))
+		if err != nil {
+			return nil, fmt.Errorf("Could not read %v: %v", testCase.File, err)
+		}
+		fileResources, err := parser.ParseResources(content)
+		if err != nil {
+			return nil, fmt.Errorf("Could not parse %v: %v", testCase.File, err)
+		}
+		resources = append(resources, fileResources...)
+		if path == "" {
+			path = filepath.ToSlash(testCase.File)
+		}
+	}
+	if path == "" {
+		return nil, fmt.Errorf("Test case must have a path or a file")
+	}
+
+	rules := map[string]bool{}
+	for _, rule := range c.RuleSet.Rules {
+		if rule.Name != "" {
+			rules[rule.Name] = true
+		}
+	}
+	actual := map[string][]string{}
+	for _, err := range c.verifyResourceList(path, resources, true) {
+		if v := ToViolation(err); !v.Suppressed {
+			actual[v.Rule] = append(actual[v.Rule], v.Message)
+		}
+	}
+
+	names := make([]string, 0, len(testCase.Expect))
+	for name := range testCase.Expect {
+		if name == "" {
+			return nil, fmt.Errorf("Test case expects violations of an unnamed rule, only named rules can be tested")
+		}
+		if !rules[name] {
+			return nil, fmt.Errorf("Test case expects violations of unknown rule %v", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	diffs := []string{}
+	for _, name := range names {
+		if diff := diffMessages(testCase.Expect[name], actual[name]); diff != "" {
+			diffs = append(diffs, name+":\n"+diff)
+		}
+	}
+	return diffs, nil
+}
+
+// Returns the expected messages that are missing prefixed with -, and the unexpected messages
+// prefixed with +, ignoring their order
+func diffMessages(expected []string, actual []string) string {
+	remaining := map[string]int{}
+	for _, message := range actual {
+		remaining[message]++
+	}
+	lines := []string{}
+	for _, message := range expected {
+		if remaining[message] > 0 {
+			remaining[message]--
+		} else {
+			lines = append(lines, "- "+message)
+		}
+	}
+	for _, message := range actual {
+		if remaining[message] > 0 {
+			remaining[message]--
+			lines = append(lines, "+ "+message)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
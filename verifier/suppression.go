@@ -0,0 +1,184 @@
+package verifier
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SkipAnnotation lists the names of rules that should not be applied to a resource, "*" skips every named rule
+const SkipAnnotation = "gatekeeper.wish.com/skip"
+
+// SkipSuppression is the suppression of violations of rules that the skip annotation of their resource lists
+const SkipSuppression = "annotation " + SkipAnnotation
+
+// expiresLayout is the date format of an exemption's expiry
+const expiresLayout = "2006-01-02"
+
+// now returns the current time, exemptions expire relative to it
+var now = time.Now
+
+// An exemption with its expiry date parsed and its path regex compiled
+type compiledExemption struct {
+	Exemption
+	// expires is the first moment the exemption no longer applies, or zero if it does not expire
+	expires time.Time
+	path    *regexp.Regexp
+}
+
+// Marks violations of a rule as suppressed if the resource skips the rule or an exemption matches it
+func suppressViolations(rule Rule, resource map[string]interface{}, path string, exemptions []*compiledExemption, errs []error) []error {
+	if rule.Name == "" || len(errs) == 0 {
+		return errs
+	}
+
+	suppression := ""
+	if skipsRule(resource, rule.Name) {
+		suppression = SkipSuppression
+	} else {
+		for _, exemption := range exemptions {
+			if exemptionMatches(exemption, rule.Name, resource, path) {
+				suppression = "exemption"
+				if exemption.Reason != "" {
+					suppression += ": " + exemption.Reason
+				}
+				break
+			}
+		}
+	}
+	if suppression == "" {
+		return errs
+	}
+
+	for _, err := range errs {
+		if v, ok := err.(*Violation); ok {
+			v.Suppressed = true
+			v.Suppression = suppression
+		}
+	}
+	return errs
+}
+
+// Checks if the skip annotation of a resource lists the rule
+func skipsRule(resource map[string]interface{}, ruleName string) bool {
+	md, ok := resource["metadata"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	annotations, ok := md["annotations"].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	skip, ok := annotations[SkipAnnotation]
+	if !ok {
+		return false
+	}
+	for _, name := range strings.Split(fmt.Sprintf("%v", skip), ",") {
+		name = strings.TrimSpace(name)
+		if name == ruleName || name == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if an unexpired exemption applies to the rule for the resource at path
+func exemptionMatches(exemption *compiledExemption, ruleName string, resource map[string]interface{}, path string) bool {
+	if !exemption.expires.IsZero() && !now().Before(exemption.expires) {
+		return false
+	}
+
+	if len(exemption.Rules) > 0 {
+		found := false
+		for _, name := range exemption.Rules {
+			if name == ruleName || name == "*" {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	id := resourceIdentifier(resource)
+	if exemption.Namespace != "" && exemption.Namespace != id.Namespace {
+		return false
+	}
+	if exemption.Name != "" && exemption.Name != id.Name {
+		return false
+	}
+	if exemption.Kind != "" && exemption.Kind != id.Kind {
+		return false
+	}
+	if exemption.path != nil && !exemption.path.MatchString(path) {
+		return false
+	}
+	return true
+}
+
+// Compiles exemptions, returns the valid ones and the errors of the exemptions without rules, namespace, name,
+// kind or path to select what they suppress, or with invalid expiry dates or path regexes, which are left out
+func compileExemptions(exemptions []Exemption) ([]*compiledExemption, []error) {
+	compiled := []*compiledExemption{}
+	errs := []error{}
+	for i, exemption := range exemptions {
+		c := &compiledExemption{Exemption: exemption}
+		valid := true
+		// An exemption without a selector would suppress every named rule for every resource
+		if len(exemption.Rules) == 0 && exemption.Namespace == "" && exemption.Name == "" && exemption.Kind == "" && exemption.Path == "" {
+			errDetails := map[string]interface{}{
+				"exemption": i,
+			}
+			errs = append(errs, NewGatekeeperError("Exemption must have at least one of the rules, namespace, name, kind or path fields: \n%v", errDetails))
+			valid = false
+		}
+		if exemption.Expires != "" {
+			expires, err := time.Parse(expiresLayout, exemption.Expires)
+			if err != nil {
+				errDetails := map[string]interface{}{
+					"exemption": i,
+					"expires":   exemption.Expires,
+				}
+				errs = append(errs, NewGatekeeperError("Invalid expires field in exemption (must be YYYY-MM-DD): \n%v", errDetails))
+				valid = false
+			}
+			// Exemptions apply until the end of their expiry date
+			c.expires = expires.AddDate(0, 0, 1)
+		}
+		if exemption.Path != "" {
+			reg, err := regexp.Compile(exemption.Path)
+			if err != nil {
+				errDetails := map[string]interface{}{
+					"exemption": i,
+					"regex":     exemption.Path,
+				}
+				errs = append(errs, NewGatekeeperError("Could not compile path regex in exemption: \n%v", errDetails))
+				valid = false
+			}
+			c.path = reg
+		}
+		if valid {
+			compiled = append(compiled, c)
+		}
+	}
+	return compiled, errs
+}
+
+// Returns the name, namespace and kind of a resource, resources without a namespace are in "default"
+func resourceIdentifier(resource map[string]interface{}) ResourceIdentifier {
+	id := ResourceIdentifier{Namespace: "default"}
+	if kind, ok := resource["kind"]; ok {
+		id.Kind = fmt.Sprintf("%v", kind)
+	}
+	if md, ok := resource["metadata"].(map[string]interface{}); ok {
+		if name, ok := md["name"]; ok {
+			id.Name = fmt.Sprintf("%v", name)
+		}
+		if namespace, ok := md["namespace"]; ok {
+			id.Namespace = fmt.Sprintf("%v", namespace)
+		}
+	}
+	return id
+}
@@ -0,0 +1 @@
+{ replicas: { prod: 10, dev: 2 } }
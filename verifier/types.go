@@ -1,26 +1,121 @@
 package verifier
 
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 // TODO: Use emebedded structs
 
 // RuleSet is a set of Rules
 type RuleSet struct {
-	Ignore []string
-	Rules  []Rule
+	Ignore     []string
+	Rules      []Rule
+	Exemptions []Exemption
+	References []string
+	// Disable lists the names of rules of earlier rulesets to remove when rulesets are merged
+	Disable []string
+}
+
+// Exemption suppresses violations of named rules for the resources it matches, empty fields match anything
+type Exemption struct {
+	Rules     []string
+	Namespace string
+	Name      string
+	Kind      string
+	Path      string
+	Expires   string
+	Reason    string
 }
 
 // Rule describes a rule
 type Rule struct {
-	Regex    string
-	Kind     string
+	Name        string
+	Description string
+	Message     string
+	Severity    string
+	Docs        string
+	Regex       string
+	Kind        string
+	// Match limits the resources the rule applies to, and Exclude removes the resources it matches
+	Match    *Match
+	Exclude  *Match
 	Type     string
+	Coerce   bool
 	RuleTree map[string]interface{}
+	// Source is the ruleset file the rule was read from
+	Source string `json:"-"`
+}
+
+// Match selects resources by more than the file name and kind, every field that is set must match
+type Match struct {
+	// APIGroups are API groups such as apps, "" is the core group and * matches any group
+	APIGroups []string
+	// APIVersions are full apiVersions such as apps/v1
+	APIVersions []string
+	// Kinds are kinds such as Deployment, a rule applies to its kind and the kinds of its match block
+	Kinds []string
+	// Namespaces match the namespace of namespaced resources and the name of Namespace resources
+	Namespaces []string
+	// NamespaceSelector matches the labels of the Namespace resource of the namespace among the verified files
+	NamespaceSelector *metav1.LabelSelector
+	// LabelSelector matches the labels of the resource
+	LabelSelector *metav1.LabelSelector
+	// Annotations must be set on the resource with the same value, an empty value matches any value
+	Annotations map[string]string
+	// Path is a regex matched against the full path of the file, unlike the regex of the rule
+	Path string
+}
+
+// Severities of a rule, from most to least severe
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+	SeverityInfo    = "info"
+)
+
+// severityLevels ranks each severity, higher is more severe
+var severityLevels = map[string]int{
+	SeverityInfo:    0,
+	SeverityWarning: 1,
+	SeverityError:   2,
+}
+
+// Violation is a structured error encountered while verifying resources
+type Violation struct {
+	Message      string                 `json:"message"`
+	Rule         string                 `json:"rule,omitempty"`
+	Description  string                 `json:"description,omitempty"`
+	Severity     string                 `json:"severity"`
+	Docs         string                 `json:"docs,omitempty"`
+	Function     string                 `json:"function,omitempty"`
+	Key          string                 `json:"key,omitempty"`
+	Expected     interface{}            `json:"expected,omitempty"`
+	Actual       interface{}            `json:"actual,omitempty"`
+	ExpectedType string                 `json:"expected_type,omitempty"`
+	ActualType   string                 `json:"actual_type,omitempty"`
+	Path         string                 `json:"path,omitempty"`
+	Resource     *ResourceIdentifier    `json:"resource,omitempty"`
+	RuleType     string                 `json:"rule_type,omitempty"`
+	Source       string                 `json:"source,omitempty"`
+	Fix          *Fix                   `json:"fix,omitempty"`
+	Suppressed   bool                   `json:"suppressed,omitempty"`
+	Suppression  string                 `json:"suppression,omitempty"`
+	Details      map[string]interface{} `json:"details,omitempty"`
+	// rule is the rule that found the violation, nil if it was not found by a rule
+	rule *compiledRule
+}
+
+// Fix describes how to fix a violation by setting the value of a key of its resource
+type Fix struct {
+	Key   string      `json:"key"`
+	Value interface{} `json:"value"`
 }
 
 // ResourceIdentifier identifies a unique resources based on name, namespace, and kind
 type ResourceIdentifier struct {
-	Name      string
-	Namespace string
-	Kind      string
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Kind      string `json:"kind"`
 }
 
 // LT describes a LT() function
@@ -28,6 +123,7 @@ type LT struct {
 	Gatekeeper bool
 	Operation  string
 	Value      float64
+	Coerce     bool
 }
 
 // GT describes a GT() function
@@ -35,6 +131,7 @@ type GT struct {
 	Gatekeeper bool
 	Operation  string
 	Value      float64
+	Coerce     bool
 }
 
 // EQ describes a EQ() function
@@ -42,6 +139,7 @@ type EQ struct {
 	Gatekeeper bool
 	Operation  string
 	Value      interface{}
+	Fix        bool
 }
 
 // AND describes a AND() function
@@ -72,6 +170,7 @@ type TAG struct {
 	Gatekeeper bool
 	Operation  string
 	Tag        string
+	Fix        bool
 }
 
 // PATH describes a PATH() function
@@ -79,4 +178,138 @@ type PATH struct {
 	Gatekeeper bool
 	Operation  string
 	Index      int
+	Fix        bool
+}
+
+// EVERY describes a EVERY() function
+type EVERY struct {
+	Gatekeeper bool
+	Operation  string
+	Tree       interface{}
+}
+
+// SOME describes a SOME() function
+type SOME struct {
+	Gatekeeper bool
+	Operation  string
+	Tree       interface{}
+}
+
+// INDEX describes a INDEX() function
+type INDEX struct {
+	Gatekeeper bool
+	Operation  string
+	Index      int
+	Tree       interface{}
+}
+
+// REF describes a REF() function
+type REF struct {
+	Gatekeeper bool
+	Operation  string
+	Kind       string
+}
+
+// SELECTS describes a SELECTS() function
+type SELECTS struct {
+	Gatekeeper bool
+	Operation  string
+	Kind       string
+}
+
+// MATCH describes a MATCH() function
+type MATCH struct {
+	Gatekeeper bool
+	Operation  string
+	Regex      string
+}
+
+// GLOB describes a GLOB() function
+type GLOB struct {
+	Gatekeeper bool
+	Operation  string
+	Pattern    string
+}
+
+// IN describes a IN() function
+type IN struct {
+	Gatekeeper bool
+	Operation  string
+	Values     []interface{}
+}
+
+// NOTIN describes a NOTIN() function
+type NOTIN struct {
+	Gatekeeper bool
+	Operation  string
+	Values     []interface{}
+}
+
+// PREFIX describes a PREFIX() function
+type PREFIX struct {
+	Gatekeeper bool
+	Operation  string
+	Value      string
+}
+
+// SUFFIX describes a SUFFIX() function
+type SUFFIX struct {
+	Gatekeeper bool
+	Operation  string
+	Value      string
+}
+
+// CONTAINS describes a CONTAINS() function
+type CONTAINS struct {
+	Gatekeeper bool
+	Operation  string
+	Value      interface{}
+}
+
+// EXISTS describes a EXISTS() function
+type EXISTS struct {
+	Gatekeeper bool
+	Operation  string
+}
+
+// DEFAULT describes a DEFAULT() function
+type DEFAULT struct {
+	Gatekeeper bool
+	Operation  string
+	Value      interface{}
+}
+
+// ABSENT describes a ABSENT() function
+type ABSENT struct {
+	Gatekeeper bool
+	Operation  string
+}
+
+// OPTIONAL describes a OPTIONAL() function
+type OPTIONAL struct {
+	Gatekeeper bool
+	Operation  string
+	Op         interface{}
+}
+
+// QLT describes a QLT() function
+type QLT struct {
+	Gatekeeper bool
+	Operation  string
+	Value      interface{}
+}
+
+// QGT describes a QGT() function
+type QGT struct {
+	Gatekeeper bool
+	Operation  string
+	Value      interface{}
+}
+
+// QRANGE describes a QRANGE() function
+type QRANGE struct {
+	Gatekeeper bool
+	Operation  string
+	Min        interface{}
+	Max        interface{}
 }
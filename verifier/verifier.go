@@ -1,211 +1,613 @@
 package verifier
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
-	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 
-	jsonnet "github.com/google/go-jsonnet"
-	"github.com/mitchellh/mapstructure"
+	"k8s.io/apimachinery/pkg/api/resource"
 
 	"github.com/wish/gatekeeper/parser"
 )
 
-var resourceIds map[ResourceIdentifier]bool
-
-// Verify verifies the given folder of Kubernetes files, then returns the errors encountered
-func Verify(ruleSet RuleSet, base string) []error {
-	errs := []error{}
-	resourceIds = make(map[ResourceIdentifier]bool)
+// VerifyDir verifies the given folder of Kubernetes files with up to jobs files verified concurrently
+// (or one per CPU if jobs is less than 1), then returns a report of the violations encountered.
+// An error is only returned if the folder could not be traversed.
+func (c *CompiledRuleSet) VerifyDir(base string, jobs int) (Report, error) {
+	return c.VerifyInputs(Inputs{Paths: []string{base}}, jobs)
+}
 
-	err := filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+// VerifyFS verifies the Kubernetes files of fsys like VerifyDir. The paths of the files are relative to the root of fsys,
+// so path variables such as PATH() only see the folders below the root.
+func (c *CompiledRuleSet) VerifyFS(fsys fs.FS, jobs int) (Report, error) {
+	paths := []string{}
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if info.IsDir() {
+		if d.IsDir() || ignored(c.RuleSet, d.Name()) {
 			return nil
 		}
-		for _, ignore := range ruleSet.Ignore {
-			if info.Name() == ignore {
-				return nil
+		paths = append(paths, path)
+		return nil
+	})
+
+	files := make([]parsedFile, len(paths))
+	checked := make([]bool, len(paths))
+	parallel(len(paths), jobs, func(i int) {
+		files[i] = loadFile(paths[i], func(name string) ([]byte, error) {
+			return fs.ReadFile(fsys, name)
+		})
+		checked[i] = true
+	})
+	report := NewReport(c.verifyParsedFiles(files, checked, jobs))
+	if err != nil {
+		return report, fmt.Errorf("Error while traversing folder: %v", err)
+	}
+	return report, nil
+}
+
+// Inputs are files, folders and resources that are not read from files, which are verified together as if
+// they were the files of a single folder, so that duplicates and references are checked across all of them
+type Inputs struct {
+	// Paths are the files and folders to verify
+	Paths []string
+	// Resources are resources that are not read from files, such as a stream read from stdin or rendered
+	// templates, by the path they are verified at
+	Resources map[string][]map[string]interface{}
+	// Changed limits the reported violations of the files of Paths to the files it lists, unless it is nil.
+	// The violations of Resources are always reported.
+	Changed []string
+}
+
+// VerifyInputs verifies files, folders and resources together with up to jobs files verified concurrently,
+// then returns a report of the violations encountered. An error is only returned if a path could not be traversed.
+func (c *CompiledRuleSet) VerifyInputs(inputs Inputs, jobs int) (Report, error) {
+	errs, err := c.verifyInputs(inputs, jobs)
+	if err != nil {
+		return NewReport(errs), fmt.Errorf("Error while traversing folder: %v", err)
+	}
+	return NewReport(errs), nil
+}
+
+// Verifies inputs, returns the errors encountered and the error that stopped the traversal of their paths
+func (c *CompiledRuleSet) verifyInputs(inputs Inputs, jobs int) ([]error, error) {
+	var only map[string]bool
+	if inputs.Changed != nil {
+		only = map[string]bool{}
+		for _, path := range inputs.Changed {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid changed file %v: %v", path, err)
 			}
+			only[abs] = true
 		}
+	}
 
-		_, err = parser.ParseObjectsFromFile(path)
-		if err != nil {
-			errs = append(errs, fmt.Errorf("Could not parse %v: %v", path, err))
+	// Files of the paths in walk order, a file that is listed or found twice is verified once
+	paths := []string{}
+	checked := []bool{}
+	seen := map[string]bool{}
+	var walkErr error
+	for _, base := range inputs.Paths {
+		walkErr = filepath.Walk(base, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || ignored(c.RuleSet, info.Name()) || seen[filepath.Clean(path)] {
+				return nil
+			}
+			seen[filepath.Clean(path)] = true
+			paths = append(paths, path)
+			if only == nil {
+				checked = append(checked, true)
+				return nil
+			}
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return err
+			}
+			checked = append(checked, only[abs])
 			return nil
+		})
+		if walkErr != nil {
+			break
 		}
+	}
 
-		// Verify structural defaults
-		errs = append(errs, verifyStructure(path)...)
+	files := make([]parsedFile, len(paths))
+	parallel(len(paths), jobs, func(i int) {
+		files[i] = loadFile(paths[i], ioutil.ReadFile)
+	})
 
-		// Verify rules
-		for _, rule := range ruleSet.Rules {
-			reg, err := regexp.Compile(rule.Regex)
-			if err != nil {
-				errs = append(errs, fmt.Errorf("Could not compile regex: %v", rule.Regex))
-			} else if reg.MatchString(info.Name()) {
-				errs = append(errs, verifyFileWithRule(path, rule)...)
-			}
+	// Resources that are not read from files follow in the order of their paths
+	resourcePaths := make([]string, 0, len(inputs.Resources))
+	for path := range inputs.Resources {
+		resourcePaths = append(resourcePaths, path)
+	}
+	sort.Strings(resourcePaths)
+	for _, path := range resourcePaths {
+		files = append(files, parsedFile{path: path, resources: inputs.Resources[path]})
+		checked = append(checked, true)
+	}
+	return c.verifyParsedFiles(files, checked, jobs), walkErr
+}
+
+// Checks if a file name is ignored by the ruleset
+func ignored(ruleSet RuleSet, name string) bool {
+	for _, ignore := range ruleSet.Ignore {
+		if name == ignore {
+			return true
 		}
+	}
+	return false
+}
 
-		return nil
-	})
+// A file that is read and parsed once, then shared by every check
+type parsedFile struct {
+	path      string
+	resources []map[string]interface{}
+	// err is set if the file could not be parsed, its resources are then only used to resolve references
+	err error
+}
 
+// Reads a file with readFile and parses it
+func loadFile(path string, readFile func(string) ([]byte, error)) parsedFile {
+	content, err := readFile(path)
 	if err != nil {
-		errs = append(errs, fmt.Errorf("Error while traversing folder: %v", err))
+		return parsedFile{path: path, err: fmt.Errorf("Could not parse %v: %v", path, err)}
 	}
-	return errs
+	resources, err := parser.ParseFile(content)
+	if err != nil {
+		return parsedFile{path: path, resources: resources, err: fmt.Errorf("Could not parse %v: %v", path, err)}
+	}
+	return parsedFile{path: path, resources: resources}
 }
 
-// Verifies a file with a rule
-func verifyFileWithRule(path string, rule Rule) []error {
+// Verifies parsed files with up to jobs files verified concurrently, errors are returned in the order of the files.
+// Every file is indexed and checked for duplicates, but only the errors of checked files are returned.
+func (c *CompiledRuleSet) verifyParsedFiles(files []parsedFile, checked []bool, jobs int) []error {
 	errs := []error{}
 
-	resources, errs := parseFile(path)
+	// Index resources and verify structural defaults in file order, so that the first of duplicate resources is kept
+	index := newResourceIndex(nil)
+	resourceIds := make(map[ResourceIdentifier]bool)
+	fileErrs := make([][]error, len(files))
+	for i, file := range files {
+		for _, resource := range file.resources {
+			index.add(resource)
+		}
+		if file.err != nil {
+			fileErrs[i] = []error{file.err}
+			continue
+		}
+		fileErrs[i] = verifyStructure(file.path, file.resources, resourceIds)
+	}
+	for i := range fileErrs {
+		if !checked[i] {
+			fileErrs[i] = nil
+		}
+	}
 
-	//Parse path variables
-	pathVars := strings.Split(path, "/")
+	parallel(len(files), jobs, func(i int) {
+		if checked[i] && files[i].err == nil {
+			fileErrs[i] = append(fileErrs[i], c.verifyFile(files[i], index)...)
+		}
+	})
 
-	//Tag map
-	tagMap := make(map[string]string)
+	for _, e := range fileErrs {
+		errs = append(errs, e...)
+	}
+	return errs
+}
 
-	// Traverse the rules tree and verify file tree on each node
-	errs = append(errs, verifyResources(rule, resources, pathVars, tagMap)...)
+// Runs fn for every index up to n on at most jobs goroutines, or one per CPU if jobs is less than 1
+func parallel(n int, jobs int, fn func(i int)) {
+	if jobs < 1 {
+		jobs = runtime.NumCPU()
+	}
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range work {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+}
+
+// Verifies the references and rules of a parsed file
+func (c *CompiledRuleSet) verifyFile(file parsedFile, index *resourceIndex) []error {
+	// Verify references to other resources
+	errs := verifyReferences(file.path, file.resources, index, c.RuleSet.References)
 
+	// Verify rules
+	for _, rule := range c.rules {
+		if rule.regex.MatchString(filepath.Base(file.path)) {
+			errs = append(errs, verifyFileWithRule(file.path, file.resources, rule, c.exemptions, index)...)
+		}
+	}
 	return errs
 }
 
-// Parses a Kubernetes configuration file into a map[string]interface
-func parseFile(path string) ([]map[string]interface{}, []error) {
-	tree := make([]map[string]interface{}, 0)
-	errs := []error{}
-
-	fileContent, err := ioutil.ReadFile(path)
+// VerifyReader verifies the Kubernetes resources read from r against the rules of the ruleset, using path in place of a file path.
+// An error is only returned if r could not be read or parsed.
+func (c *CompiledRuleSet) VerifyReader(path string, r io.Reader) (Report, error) {
+	content, err := ioutil.ReadAll(r)
 	if err != nil {
-		fmt.Println("Cannot read " + path)
-		os.Exit(1)
+		return Report{}, fmt.Errorf("Could not read %v: %v", path, err)
 	}
+	resources, err := parser.ParseResources(content)
+	if err != nil {
+		return Report{}, fmt.Errorf("Could not parse %v: %v", path, err)
+	}
+	return NewReport(c.verifyResourceList(path, resources, true)), nil
+}
 
-	resources := strings.Split(strings.TrimSuffix(strings.TrimSpace(string(fileContent)), "..."), "---\n")
-	for _, resource := range resources {
-		if strings.TrimSpace(resource) == "" {
+// VerifyResource verifies a single resource against the rules of the ruleset, using path in place of a file path.
+// The other resources of its namespace are not known, so rules that use REF() or SELECTS() are not applied and
+// the reference checks of the ruleset are not run.
+func (c *CompiledRuleSet) VerifyResource(path string, resource map[string]interface{}) []error {
+	return c.verifyResourceList(path, []map[string]interface{}{resource}, false)
+}
+
+// Verifies resources that are not read from a folder against the rules of the ruleset, rules that use REF() or
+// SELECTS() are only applied if references is set
+func (c *CompiledRuleSet) verifyResourceList(path string, resources []map[string]interface{}, references bool) []error {
+	errs := []error{}
+	index := newResourceIndex(resources)
+
+	//Parse path variables
+	pathVars := strings.Split(path, "/")
+
+	for _, rule := range c.rules {
+		if rule.references && !references {
 			continue
 		}
-		var resourceMap map[string]interface{}
-		if err := json.Unmarshal([]byte(resource), &resourceMap); err != nil {
-			errs = append(errs, fmt.Errorf("Error unmarshalling file %v: %v", path, err.Error()))
+		if rule.regex.MatchString(filepath.Base(path)) {
+			tagMap := make(map[string]string)
+			errs = append(errs, verifyResources(rule, resources, pathVars, tagMap, c.exemptions, index)...)
 		}
-		tree = append(tree, resourceMap)
 	}
-	return tree, errs
+	return errs
+}
+
+// Verifies the resources of a file with a rule
+func verifyFileWithRule(path string, resources []map[string]interface{}, rule *compiledRule, exemptions []*compiledExemption, index *resourceIndex) []error {
+	//Parse path variables
+	pathVars := strings.Split(path, "/")
+
+	//Tag map
+	tagMap := make(map[string]string)
+
+	// Traverse the rules tree and verify file tree on each node
+	return verifyResources(rule, resources, pathVars, tagMap, exemptions, index)
 }
 
 // Verifies a list of resources with a rule
-func verifyResources(rule Rule, resources []map[string]interface{}, pathVars []string, tagMap map[string]string) []error {
+func verifyResources(rule *compiledRule, resources []map[string]interface{}, pathVars []string, tagMap map[string]string, exemptions []*compiledExemption, index *resourceIndex) []error {
 	errs := []error{}
 
 	for _, resource := range resources {
+		resourceErrs := verifyResource(rule, resource, pathVars, tagMap, index)
+		resourceErrs = annotateViolations(rule, resourceErrs)
+		identifyViolations(resourceIdentifier(resource), resourceErrs)
+		errs = append(errs, suppressViolations(rule.Rule, resource, strings.Join(pathVars, "/"), exemptions, resourceErrs)...)
+	}
 
-		// Check kind exists
-		if _, ok := resource["kind"]; !ok {
-			errDetails := map[string]interface{}{
-				"path":     strings.Join(pathVars, "/"),
-				"resource": resource,
-			}
-			errs = append(errs, NewGatekeeperError("Resource does not have 'kind' field: \n%v", errDetails))
-			continue
-		}
+	return errs
+}
 
-		// Verify any deny rules for this resource kind
-		if rule.Kind == resource["kind"] && rule.Type == "deny" && len(rule.RuleTree) == 0 {
-			errDetails := map[string]interface{}{
-				"path": strings.Join(pathVars, "/"),
-				"kind": resource["kind"],
-			}
-			errs = append(errs, NewGatekeeperError("Kind not allowed due to deny rule: \n%v", errDetails))
-			continue
+// Verifies a resource with a rule, returns the errors encountered
+func verifyResource(rule *compiledRule, resource map[string]interface{}, pathVars []string, tagMap map[string]string, index *resourceIndex) []error {
+	errs := []error{}
+
+	// Check kind exists
+	if _, ok := resource["kind"]; !ok {
+		errDetails := map[string]interface{}{
+			"path":     strings.Join(pathVars, "/"),
+			"resource": resource,
 		}
+		errs = append(errs, NewGatekeeperError("Resource does not have 'kind' field: \n%v", errDetails))
+		return errs
+	}
 
-		if rule.Kind == resource["kind"] {
-			var allow bool
-			if rule.Type == "allow" {
-				allow = true
-			} else if rule.Type == "deny" {
-				allow = false
-			} else {
-				errDetails := map[string]interface{}{
-					"path": strings.Join(pathVars, "/"),
-					"type": rule.Type,
-				}
-				errs = append(errs, NewGatekeeperError("Invalid type field in rule (must be allow or deny): \n%v", errDetails))
-				return errs
-			}
-			errs = append(errs, verifyResourcesTraverseHelper(rule.RuleTree, resource, pathVars, tagMap, "", allow)...)
+	if !rule.applies(strings.Join(pathVars, "/"), resource, index) {
+		return errs
+	}
+
+	// Verify any deny rules for this resource kind
+	if !rule.allow && len(rule.tree.keys) == 0 {
+		errDetails := map[string]interface{}{
+			"path": strings.Join(pathVars, "/"),
+			"kind": resource["kind"],
 		}
+		errs = append(errs, NewGatekeeperError("Kind not allowed due to deny rule: \n%v", errDetails))
+		return errs
 	}
 
+	errs = append(errs, verifyResourcesTraverseHelper(rule.tree, resource, pathVars, tagMap, index.scope(resourceIdentifier(resource).Namespace), nil, rule.allow)...)
 	return errs
 }
 
 // Traverses rule tree to properly apply rules
-func verifyResourcesTraverseHelper(ruleTree map[string]interface{}, resourceTree map[string]interface{}, pathVars []string, tagMap map[string]string, parentKey string, allow bool) []error {
+func verifyResourcesTraverseHelper(ruleTree *objectNode, resourceTree map[string]interface{}, pathVars []string, tagMap map[string]string, scope *namespaceScope, indexes []int, allow bool) []error {
 	errs := []error{}
-	for k, v := range ruleTree {
-		// Check resource tree has key
+	for i, k := range ruleTree.keys {
+		v := ruleTree.children[i]
+		key := expandKey(ruleTree.paths[i], indexes)
+
+		// Check resource tree has key, unless the rule checks for its presence
 		if _, ok := resourceTree[k]; !ok {
+			if f, ok := v.(*function); ok && f.checksPresence() {
+				errs = append(errs, applyRule(f, key, missingKey{}, pathVars, tagMap, scope, indexes, allow)...)
+				continue
+			}
 			errDetails := map[string]interface{}{
 				"path": strings.Join(pathVars, "/"),
-				"key":  k,
+				"key":  key,
 			}
 			errs = append(errs, NewGatekeeperError("Resource does not have expected key: \n%v", errDetails))
 			continue
 		}
-		key := k
-		if parentKey != "" {
-			key = parentKey + "." + k
-		}
 
-		switch t := v.(type) {
-		case []interface{}:
-			// TODO: arrays???
-		case map[string]interface{}:
-			if _, ok := t["gatekeeper"]; ok {
-				errs = append(errs, applyRule(t, key, resourceTree[k], pathVars, tagMap, allow)...)
-			} else {
-				switch r := resourceTree[k].(type) {
-				case map[string]interface{}:
-					errs = append(errs, verifyResourcesTraverseHelper(t, r, pathVars, tagMap, key, allow)...)
-				default:
-					errDetails := map[string]interface{}{
-						"path":  strings.Join(pathVars, "/"),
-						"key":   k,
-						"value": r,
-					}
-					errs = append(errs, NewGatekeeperError("Expected object, but key does not contain an object for a value: \n%v", errDetails))
+		errs = append(errs, verifyValue(v, resourceTree[k], pathVars, tagMap, scope, key, indexes, allow)...)
+	}
+	return errs
+}
+
+// Applies a node of the rule tree to the value found at the same position in the resource tree, key is the
+// expanded key path of the node
+func verifyValue(node ruleNode, val interface{}, pathVars []string, tagMap map[string]string, scope *namespaceScope, key string, indexes []int, allow bool) []error {
+	errs := []error{}
+	switch t := node.(type) {
+	case *arrayNode:
+		// Arrays in the rule tree are matched positionally against arrays in the resource tree
+		r, ok := val.([]interface{})
+		if !ok {
+			errDetails := map[string]interface{}{
+				"path":  strings.Join(pathVars, "/"),
+				"key":   key,
+				"value": val,
+			}
+			errs = append(errs, NewGatekeeperError("Expected array, but key does not contain an array for a value: \n%v", errDetails))
+			return errs
+		}
+		for i, elementRule := range t.elements {
+			if i > len(r)-1 {
+				errDetails := map[string]interface{}{
+					"path":  strings.Join(pathVars, "/"),
+					"key":   key,
+					"index": i,
 				}
+				errs = append(errs, NewGatekeeperError("Resource does not have expected index: \n%v", errDetails))
+				continue
 			}
+			errs = append(errs, verifyValue(elementRule, r[i], pathVars, tagMap, scope, expandKey(t.paths[i], indexes), indexes, allow)...)
+		}
+	case *function:
+		errs = append(errs, applyRule(t, key, val, pathVars, tagMap, scope, indexes, allow)...)
+	case *objectNode:
+		switch r := val.(type) {
+		case map[string]interface{}:
+			errs = append(errs, verifyResourcesTraverseHelper(t, r, pathVars, tagMap, scope, indexes, allow)...)
+		default:
+			errDetails := map[string]interface{}{
+				"path":  strings.Join(pathVars, "/"),
+				"key":   key,
+				"value": r,
+			}
+			errs = append(errs, NewGatekeeperError("Expected object, but key does not contain an object for a value: \n%v", errDetails))
 		}
 	}
 	return errs
 }
 
+// Checks if a value satisfies a node of the rule tree, TAG() values are only recorded if it does
+func satisfiesValue(node ruleNode, val interface{}, pathVars []string, tagMap map[string]string, scope *namespaceScope) bool {
+	trialTagMap := make(map[string]string)
+	for k, v := range tagMap {
+		trialTagMap[k] = v
+	}
+	if len(verifyValue(node, val, pathVars, trialTagMap, scope, "", nil, true)) > 0 {
+		return false
+	}
+	for k, v := range trialTagMap {
+		tagMap[k] = v
+	}
+	return true
+}
+
+// scalarType is the expected type of functions that compare strings, numbers and booleans
+const scalarType = "string, number or boolean"
+
+// Returns the JSON type name of a resource value
+func typeName(val interface{}) string {
+	switch val.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case float64, int:
+		return "number"
+	case bool:
+		return "boolean"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	case missingKey:
+		return "missing"
+	}
+	return fmt.Sprintf("%T", val)
+}
+
+// Checks if val is a string, number or boolean
+func isScalar(val interface{}) bool {
+	switch val.(type) {
+	case string, float64, int, bool:
+		return true
+	}
+	return false
+}
+
+// Converts val to a number, numeric strings are only converted if coerce is set
+func toNumber(val interface{}, coerce bool) (float64, bool) {
+	switch v := val.(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	case string:
+		if !coerce {
+			return 0, false
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// Returns a violation for a function applied to a value of the wrong type
+func typeMismatch(function string, expectedType string, val interface{}, pathVars []string, key string, allow bool) error {
+	errDetails := map[string]interface{}{
+		"path":          strings.Join(pathVars, "/"),
+		"key":           key,
+		"expected_type": expectedType,
+		"actual_type":   typeName(val),
+		"actual":        val,
+	}
+	if allow {
+		errDetails["rule_type"] = "allow"
+	} else {
+		errDetails["rule_type"] = "deny"
+	}
+	return NewGatekeeperError("Type mismatch in "+function+"() rule: \n%v", errDetails)
+}
+
+// Parses a Kubernetes quantity such as "512Mi" or "250m" from a string or number
+func parseQuantity(val interface{}) (resource.Quantity, error) {
+	switch v := val.(type) {
+	case string:
+		return resource.ParseQuantity(v)
+	case float64:
+		return resource.ParseQuantity(strconv.FormatFloat(v, 'f', -1, 64))
+	case int:
+		return *resource.NewQuantity(int64(v), resource.DecimalSI), nil
+	}
+	return resource.Quantity{}, fmt.Errorf("%v is not a quantity", val)
+}
+
+// missingKey is the value given to EXISTS(), DEFAULT(), ABSENT() and OPTIONAL() when the key is not in the resource
+type missingKey struct{}
+
+// Checks if val is equal to one of values
+func containsValue(values []interface{}, val interface{}) bool {
+	resourceVal := fmt.Sprintf("%v", val)
+	for _, v := range values {
+		if fmt.Sprintf("%v", v) == resourceVal {
+			return true
+		}
+	}
+	return false
+}
+
+// Checks if val contains element, as an element of an array or a substring of a string
+func containsElement(val interface{}, element interface{}) bool {
+	if arr, ok := val.([]interface{}); ok {
+		return containsValue(arr, element)
+	}
+	return strings.Contains(fmt.Sprintf("%v", val), fmt.Sprintf("%v", element))
+}
+
+// Returns the key of an array element
+func indexKey(key string, index int) string {
+	return fmt.Sprintf("%v[%v]", key, index)
+}
+
+// Key paths of the rule tree are computed when the rule is compiled, the index of an EVERY() or SOME() element
+// is anyIndex as it is only known when a resource is verified
+const anyIndex = "[*]"
+
+// Returns the key path of a rule tree node with each anyIndex replaced by the index of the enclosing EVERY() element
+func expandKey(path string, indexes []int) string {
+	for _, i := range indexes {
+		path = strings.Replace(path, anyIndex, fmt.Sprintf("[%v]", i), 1)
+	}
+	return path
+}
+
+// Names of the ruleset functions for each gatekeeper operation
+var functionNames = map[string]string{
+	"&":        "AND",
+	"|":        "OR",
+	"!":        "NOT",
+	"<":        "LT",
+	">":        "GT",
+	"=":        "EQ",
+	"tag":      "TAG",
+	"path":     "PATH",
+	"every":    "EVERY",
+	"some":     "SOME",
+	"index":    "INDEX",
+	"ref":      "REF",
+	"selects":  "SELECTS",
+	"match":    "MATCH",
+	"glob":     "GLOB",
+	"in":       "IN",
+	"notin":    "NOTIN",
+	"prefix":   "PREFIX",
+	"suffix":   "SUFFIX",
+	"contains": "CONTAINS",
+	"exists":   "EXISTS",
+	"default":  "DEFAULT",
+	"absent":   "ABSENT",
+	"optional": "OPTIONAL",
+	"qlt":      "QLT",
+	"qgt":      "QGT",
+	"qrange":   "QRANGE",
+}
+
 // Applies a rule to a key/value pair, returns list of errors encountered
-func applyRule(rule map[string]interface{}, key string, val interface{}, pathVars []string, tagMap map[string]string, allow bool) []error {
+func applyRule(f *function, key string, val interface{}, pathVars []string, tagMap map[string]string, scope *namespaceScope, indexes []int, allow bool) []error {
+	errs := applyFunction(f, key, val, pathVars, tagMap, scope, indexes, allow)
+	for _, err := range errs {
+		if v, ok := err.(*Violation); ok && v.Function == "" {
+			v.Function = f.name
+		}
+	}
+	return errs
+}
+
+// Applies a gatekeeper function to a key/value pair, returns list of errors encountered
+func applyFunction(f *function, key string, val interface{}, pathVars []string, tagMap map[string]string, scope *namespaceScope, indexes []int, allow bool) []error {
 	errs := []error{}
-	switch rule["operation"] {
+	switch f.operation {
 	case "&":
-		var and AND
-		if err := mapstructure.Decode(rule, &and); err != nil {
-			errs = append(errs, err)
+		and := f.args.(*AND)
+		rulePassed, mismatches := checkRule(f, key, val, pathVars, tagMap, scope, allow)
+		if len(mismatches) > 0 {
+			errs = append(errs, mismatches...)
 			return errs
 		}
-		rulePassed := checkRule(and.Op1, val, pathVars, tagMap) && checkRule(and.Op2, val, pathVars, tagMap)
 		errDetails := map[string]interface{}{
 			"path":        strings.Join(pathVars, "/"),
 			"key":         key,
@@ -221,12 +623,12 @@ func applyRule(rule map[string]interface{}, key string, val interface{}, pathVar
 			errs = append(errs, NewGatekeeperError("Broken AND() rule: \n%v", errDetails))
 		}
 	case "|":
-		var or OR
-		if err := mapstructure.Decode(rule, &or); err != nil {
-			errs = append(errs, err)
+		or := f.args.(*OR)
+		rulePassed, mismatches := checkRule(f, key, val, pathVars, tagMap, scope, allow)
+		if len(mismatches) > 0 {
+			errs = append(errs, mismatches...)
 			return errs
 		}
-		rulePassed := checkRule(or.Op1, val, pathVars, tagMap) || checkRule(or.Op2, val, pathVars, tagMap)
 		errDetails := map[string]interface{}{
 			"path":        strings.Join(pathVars, "/"),
 			"key":         key,
@@ -242,12 +644,12 @@ func applyRule(rule map[string]interface{}, key string, val interface{}, pathVar
 			errs = append(errs, NewGatekeeperError("Broken OR() rule: \n%v", errDetails))
 		}
 	case "!":
-		var not NOT
-		if err := mapstructure.Decode(rule, &not); err != nil {
-			errs = append(errs, err)
+		not := f.args.(*NOT)
+		rulePassed, mismatches := checkRule(f, key, val, pathVars, tagMap, scope, allow)
+		if len(mismatches) > 0 {
+			errs = append(errs, mismatches...)
 			return errs
 		}
-		rulePassed := !checkRule(not.Op, val, pathVars, tagMap)
 		errDetails := map[string]interface{}{
 			"path":      strings.Join(pathVars, "/"),
 			"key":       key,
@@ -262,12 +664,12 @@ func applyRule(rule map[string]interface{}, key string, val interface{}, pathVar
 			errs = append(errs, NewGatekeeperError("Broken NOT() rule: \n%v", errDetails))
 		}
 	case "<":
-		var lt LT
-		if err := mapstructure.Decode(rule, &lt); err != nil {
-			errs = append(errs, err)
+		lt := f.args.(*LT)
+		resourceVal, ok := toNumber(val, lt.Coerce)
+		if !ok {
+			errs = append(errs, typeMismatch("LT", "number", val, pathVars, key, allow))
 			return errs
 		}
-		resourceVal := val.(float64)
 		rulePassed := resourceVal < lt.Value
 		errDetails := map[string]interface{}{
 			"path":     strings.Join(pathVars, "/"),
@@ -283,12 +685,12 @@ func applyRule(rule map[string]interface{}, key string, val interface{}, pathVar
 			errs = append(errs, NewGatekeeperError("Broken LT() rule: \n%v", errDetails))
 		}
 	case ">":
-		var gt GT
-		if err := mapstructure.Decode(rule, &gt); err != nil {
-			errs = append(errs, err)
+		gt := f.args.(*GT)
+		resourceVal, ok := toNumber(val, gt.Coerce)
+		if !ok {
+			errs = append(errs, typeMismatch("GT", "number", val, pathVars, key, allow))
 			return errs
 		}
-		resourceVal := val.(float64)
 		rulePassed := resourceVal > gt.Value
 		errDetails := map[string]interface{}{
 			"path":     strings.Join(pathVars, "/"),
@@ -304,9 +706,9 @@ func applyRule(rule map[string]interface{}, key string, val interface{}, pathVar
 			errs = append(errs, NewGatekeeperError("Broken GT() rule: \n%v", errDetails))
 		}
 	case "=":
-		var eq EQ
-		if err := mapstructure.Decode(rule, &eq); err != nil {
-			errs = append(errs, err)
+		eq := f.args.(*EQ)
+		if !isScalar(val) {
+			errs = append(errs, typeMismatch("EQ", scalarType, val, pathVars, key, allow))
 			return errs
 		}
 		resourceVal := fmt.Sprintf("%v", val)
@@ -320,15 +722,395 @@ func applyRule(rule map[string]interface{}, key string, val interface{}, pathVar
 		}
 		if !rulePassed && allow {
 			errDetails["rule_type"] = "allow"
-			errs = append(errs, NewGatekeeperError("Broken EQ() rule: \n%v", errDetails))
+			errs = append(errs, withFix(NewGatekeeperError("Broken EQ() rule: \n%v", errDetails), eq.Fix, key, eq.Value))
 		} else if rulePassed && !allow {
 			errDetails["rule_type"] = "deny"
 			errs = append(errs, NewGatekeeperError("Broken EQ() rule: \n%v", errDetails))
 		}
+	case "qlt":
+		qlt := f.args.(*QLT)
+		limit := f.quantities[0]
+		resourceVal, err := parseQuantity(val)
+		if err != nil {
+			errs = append(errs, typeMismatch("QLT", "quantity", val, pathVars, key, allow))
+			return errs
+		}
+		rulePassed := resourceVal.Cmp(limit) < 0
+		errDetails := map[string]interface{}{
+			"path":     strings.Join(pathVars, "/"),
+			"key":      key,
+			"expected": qlt.Value,
+			"actual":   val,
+		}
+		if !rulePassed && allow {
+			errDetails["rule_type"] = "allow"
+			errs = append(errs, NewGatekeeperError("Broken QLT() rule: \n%v", errDetails))
+		} else if rulePassed && !allow {
+			errDetails["rule_type"] = "deny"
+			errs = append(errs, NewGatekeeperError("Broken QLT() rule: \n%v", errDetails))
+		}
+	case "qgt":
+		qgt := f.args.(*QGT)
+		limit := f.quantities[0]
+		resourceVal, err := parseQuantity(val)
+		if err != nil {
+			errs = append(errs, typeMismatch("QGT", "quantity", val, pathVars, key, allow))
+			return errs
+		}
+		rulePassed := resourceVal.Cmp(limit) > 0
+		errDetails := map[string]interface{}{
+			"path":     strings.Join(pathVars, "/"),
+			"key":      key,
+			"expected": qgt.Value,
+			"actual":   val,
+		}
+		if !rulePassed && allow {
+			errDetails["rule_type"] = "allow"
+			errs = append(errs, NewGatekeeperError("Broken QGT() rule: \n%v", errDetails))
+		} else if rulePassed && !allow {
+			errDetails["rule_type"] = "deny"
+			errs = append(errs, NewGatekeeperError("Broken QGT() rule: \n%v", errDetails))
+		}
+	case "qrange":
+		qrange := f.args.(*QRANGE)
+		min, max := f.quantities[0], f.quantities[1]
+		resourceVal, err := parseQuantity(val)
+		if err != nil {
+			errs = append(errs, typeMismatch("QRANGE", "quantity", val, pathVars, key, allow))
+			return errs
+		}
+		rulePassed := resourceVal.Cmp(min) >= 0 && resourceVal.Cmp(max) <= 0
+		errDetails := map[string]interface{}{
+			"path":     strings.Join(pathVars, "/"),
+			"key":      key,
+			"expected": []interface{}{qrange.Min, qrange.Max},
+			"actual":   val,
+		}
+		if !rulePassed && allow {
+			errDetails["rule_type"] = "allow"
+			errs = append(errs, NewGatekeeperError("Broken QRANGE() rule: \n%v", errDetails))
+		} else if rulePassed && !allow {
+			errDetails["rule_type"] = "deny"
+			errs = append(errs, NewGatekeeperError("Broken QRANGE() rule: \n%v", errDetails))
+		}
+	case "match":
+		match := f.args.(*MATCH)
+		if !isScalar(val) {
+			errs = append(errs, typeMismatch("MATCH", scalarType, val, pathVars, key, allow))
+			return errs
+		}
+		reg := f.regex
+		resourceVal := fmt.Sprintf("%v", val)
+		rulePassed := reg.MatchString(resourceVal)
+		errDetails := map[string]interface{}{
+			"path":     strings.Join(pathVars, "/"),
+			"key":      key,
+			"expected": match.Regex,
+			"actual":   resourceVal,
+		}
+		if !rulePassed && allow {
+			errDetails["rule_type"] = "allow"
+			errs = append(errs, NewGatekeeperError("Broken MATCH() rule: \n%v", errDetails))
+		} else if rulePassed && !allow {
+			errDetails["rule_type"] = "deny"
+			errs = append(errs, NewGatekeeperError("Broken MATCH() rule: \n%v", errDetails))
+		}
+	case "glob":
+		glob := f.args.(*GLOB)
+		if !isScalar(val) {
+			errs = append(errs, typeMismatch("GLOB", scalarType, val, pathVars, key, allow))
+			return errs
+		}
+		resourceVal := fmt.Sprintf("%v", val)
+		rulePassed := f.regex.MatchString(resourceVal)
+		errDetails := map[string]interface{}{
+			"path":     strings.Join(pathVars, "/"),
+			"key":      key,
+			"expected": glob.Pattern,
+			"actual":   resourceVal,
+		}
+		if !rulePassed && allow {
+			errDetails["rule_type"] = "allow"
+			errs = append(errs, NewGatekeeperError("Broken GLOB() rule: \n%v", errDetails))
+		} else if rulePassed && !allow {
+			errDetails["rule_type"] = "deny"
+			errs = append(errs, NewGatekeeperError("Broken GLOB() rule: \n%v", errDetails))
+		}
+	case "in":
+		in := f.args.(*IN)
+		if !isScalar(val) {
+			errs = append(errs, typeMismatch("IN", scalarType, val, pathVars, key, allow))
+			return errs
+		}
+		resourceVal := fmt.Sprintf("%v", val)
+		rulePassed := containsValue(in.Values, val)
+		errDetails := map[string]interface{}{
+			"path":     strings.Join(pathVars, "/"),
+			"key":      key,
+			"expected": in.Values,
+			"actual":   resourceVal,
+		}
+		if !rulePassed && allow {
+			errDetails["rule_type"] = "allow"
+			errs = append(errs, NewGatekeeperError("Broken IN() rule: \n%v", errDetails))
+		} else if rulePassed && !allow {
+			errDetails["rule_type"] = "deny"
+			errs = append(errs, NewGatekeeperError("Broken IN() rule: \n%v", errDetails))
+		}
+	case "notin":
+		notIn := f.args.(*NOTIN)
+		if !isScalar(val) {
+			errs = append(errs, typeMismatch("NOTIN", scalarType, val, pathVars, key, allow))
+			return errs
+		}
+		resourceVal := fmt.Sprintf("%v", val)
+		rulePassed := !containsValue(notIn.Values, val)
+		errDetails := map[string]interface{}{
+			"path":     strings.Join(pathVars, "/"),
+			"key":      key,
+			"expected": notIn.Values,
+			"actual":   resourceVal,
+		}
+		if !rulePassed && allow {
+			errDetails["rule_type"] = "allow"
+			errs = append(errs, NewGatekeeperError("Broken NOTIN() rule: \n%v", errDetails))
+		} else if rulePassed && !allow {
+			errDetails["rule_type"] = "deny"
+			errs = append(errs, NewGatekeeperError("Broken NOTIN() rule: \n%v", errDetails))
+		}
+	case "prefix":
+		prefix := f.args.(*PREFIX)
+		if !isScalar(val) {
+			errs = append(errs, typeMismatch("PREFIX", scalarType, val, pathVars, key, allow))
+			return errs
+		}
+		resourceVal := fmt.Sprintf("%v", val)
+		rulePassed := strings.HasPrefix(resourceVal, prefix.Value)
+		errDetails := map[string]interface{}{
+			"path":     strings.Join(pathVars, "/"),
+			"key":      key,
+			"expected": prefix.Value,
+			"actual":   resourceVal,
+		}
+		if !rulePassed && allow {
+			errDetails["rule_type"] = "allow"
+			errs = append(errs, NewGatekeeperError("Broken PREFIX() rule: \n%v", errDetails))
+		} else if rulePassed && !allow {
+			errDetails["rule_type"] = "deny"
+			errs = append(errs, NewGatekeeperError("Broken PREFIX() rule: \n%v", errDetails))
+		}
+	case "suffix":
+		suffix := f.args.(*SUFFIX)
+		if !isScalar(val) {
+			errs = append(errs, typeMismatch("SUFFIX", scalarType, val, pathVars, key, allow))
+			return errs
+		}
+		resourceVal := fmt.Sprintf("%v", val)
+		rulePassed := strings.HasSuffix(resourceVal, suffix.Value)
+		errDetails := map[string]interface{}{
+			"path":     strings.Join(pathVars, "/"),
+			"key":      key,
+			"expected": suffix.Value,
+			"actual":   resourceVal,
+		}
+		if !rulePassed && allow {
+			errDetails["rule_type"] = "allow"
+			errs = append(errs, NewGatekeeperError("Broken SUFFIX() rule: \n%v", errDetails))
+		} else if rulePassed && !allow {
+			errDetails["rule_type"] = "deny"
+			errs = append(errs, NewGatekeeperError("Broken SUFFIX() rule: \n%v", errDetails))
+		}
+	case "contains":
+		contains := f.args.(*CONTAINS)
+		switch val.(type) {
+		case string, []interface{}:
+		default:
+			errs = append(errs, typeMismatch("CONTAINS", "string or array", val, pathVars, key, allow))
+			return errs
+		}
+		resourceVal := val
+		rulePassed := containsElement(val, contains.Value)
+		errDetails := map[string]interface{}{
+			"path":     strings.Join(pathVars, "/"),
+			"key":      key,
+			"expected": contains.Value,
+			"actual":   resourceVal,
+		}
+		if !rulePassed && allow {
+			errDetails["rule_type"] = "allow"
+			errs = append(errs, NewGatekeeperError("Broken CONTAINS() rule: \n%v", errDetails))
+		} else if rulePassed && !allow {
+			errDetails["rule_type"] = "deny"
+			errs = append(errs, NewGatekeeperError("Broken CONTAINS() rule: \n%v", errDetails))
+		}
+	case "exists":
+		_, missing := val.(missingKey)
+		rulePassed := !missing
+		errDetails := map[string]interface{}{
+			"path": strings.Join(pathVars, "/"),
+			"key":  key,
+		}
+		if !rulePassed && allow {
+			errDetails["rule_type"] = "allow"
+			errs = append(errs, NewGatekeeperError("Broken EXISTS() rule: \n%v", errDetails))
+		} else if rulePassed && !allow {
+			errDetails["rule_type"] = "deny"
+			errDetails["actual"] = val
+			errs = append(errs, NewGatekeeperError("Broken EXISTS() rule: \n%v", errDetails))
+		}
+	case "default":
+		def := f.args.(*DEFAULT)
+		_, missing := val.(missingKey)
+		rulePassed := !missing
+		errDetails := map[string]interface{}{
+			"path": strings.Join(pathVars, "/"),
+			"key":  key,
+		}
+		if !rulePassed && allow {
+			errDetails["rule_type"] = "allow"
+			errDetails["expected"] = def.Value
+			errs = append(errs, withFix(NewGatekeeperError("Broken DEFAULT() rule: \n%v", errDetails), true, key, def.Value))
+		} else if rulePassed && !allow {
+			errDetails["rule_type"] = "deny"
+			errDetails["actual"] = val
+			errs = append(errs, NewGatekeeperError("Broken DEFAULT() rule: \n%v", errDetails))
+		}
+	case "absent":
+		_, missing := val.(missingKey)
+		rulePassed := missing
+		errDetails := map[string]interface{}{
+			"path": strings.Join(pathVars, "/"),
+			"key":  key,
+		}
+		if !rulePassed && allow {
+			errDetails["rule_type"] = "allow"
+			errDetails["actual"] = val
+			errs = append(errs, NewGatekeeperError("Broken ABSENT() rule: \n%v", errDetails))
+		} else if rulePassed && !allow {
+			errDetails["rule_type"] = "deny"
+			errs = append(errs, NewGatekeeperError("Broken ABSENT() rule: \n%v", errDetails))
+		}
+	case "optional":
+		if _, missing := val.(missingKey); missing {
+			return errs
+		}
+		errs = append(errs, verifyValue(f.tree, val, pathVars, tagMap, scope, key, indexes, allow)...)
+	case "every":
+		every := f.args.(*EVERY)
+		resourceVal, ok := val.([]interface{})
+		if !ok {
+			errs = append(errs, typeMismatch("EVERY", "array", val, pathVars, key, allow))
+			return errs
+		}
+		// A deny rule is only broken if every element satisfies the tree, like checkRule evaluates EVERY()
+		if !allow {
+			for _, element := range resourceVal {
+				if !satisfiesValue(f.tree, element, pathVars, tagMap, scope) {
+					return errs
+				}
+			}
+			errDetails := map[string]interface{}{
+				"path":      strings.Join(pathVars, "/"),
+				"key":       key,
+				"tree":      every.Tree,
+				"rule_type": "deny",
+			}
+			errs = append(errs, NewGatekeeperError("Broken EVERY() rule: \n%v", errDetails))
+			return errs
+		}
+		for i, element := range resourceVal {
+			elementIndexes := append(indexes[:len(indexes):len(indexes)], i)
+			errs = append(errs, verifyValue(f.tree, element, pathVars, tagMap, scope, indexKey(key, i), elementIndexes, allow)...)
+		}
+	case "some":
+		some := f.args.(*SOME)
+		resourceVal, ok := val.([]interface{})
+		if !ok {
+			errs = append(errs, typeMismatch("SOME", "array", val, pathVars, key, allow))
+			return errs
+		}
+		rulePassed := false
+		for _, element := range resourceVal {
+			if satisfiesValue(f.tree, element, pathVars, tagMap, scope) {
+				rulePassed = true
+				break
+			}
+		}
+		errDetails := map[string]interface{}{
+			"path": strings.Join(pathVars, "/"),
+			"key":  key,
+			"tree": some.Tree,
+		}
+		if !rulePassed && allow {
+			errDetails["rule_type"] = "allow"
+			errs = append(errs, NewGatekeeperError("Broken SOME() rule: \n%v", errDetails))
+		} else if rulePassed && !allow {
+			errDetails["rule_type"] = "deny"
+			errs = append(errs, NewGatekeeperError("Broken SOME() rule: \n%v", errDetails))
+		}
+	case "index":
+		index := f.args.(*INDEX)
+		resourceVal, ok := val.([]interface{})
+		if !ok {
+			errs = append(errs, typeMismatch("INDEX", "array", val, pathVars, key, allow))
+			return errs
+		}
+		if index.Index < 0 || index.Index > len(resourceVal)-1 {
+			errDetails := map[string]interface{}{
+				"path":  strings.Join(pathVars, "/"),
+				"key":   key,
+				"index": index.Index,
+			}
+			errs = append(errs, NewGatekeeperError("Resource does not have expected index: \n%v", errDetails))
+			return errs
+		}
+		errs = append(errs, verifyValue(f.tree, resourceVal[index.Index], pathVars, tagMap, scope, indexKey(key, index.Index), indexes, allow)...)
+	case "ref":
+		ref := f.args.(*REF)
+		if !isScalar(val) {
+			errs = append(errs, typeMismatch("REF", scalarType, val, pathVars, key, allow))
+			return errs
+		}
+		resourceVal := fmt.Sprintf("%v", val)
+		rulePassed := scope.exists(ref.Kind, resourceVal)
+		errDetails := map[string]interface{}{
+			"path":     strings.Join(pathVars, "/"),
+			"key":      key,
+			"expected": ref.Kind,
+			"actual":   resourceVal,
+		}
+		if !rulePassed && allow {
+			errDetails["rule_type"] = "allow"
+			errs = append(errs, NewGatekeeperError("Broken REF() rule: \n%v", errDetails))
+		} else if rulePassed && !allow {
+			errDetails["rule_type"] = "deny"
+			errs = append(errs, NewGatekeeperError("Broken REF() rule: \n%v", errDetails))
+		}
+	case "selects":
+		selects := f.args.(*SELECTS)
+		selector, ok := val.(map[string]interface{})
+		if !ok {
+			errs = append(errs, typeMismatch("SELECTS", "object", val, pathVars, key, allow))
+			return errs
+		}
+		rulePassed := scope.selects(selects.Kind, selector)
+		errDetails := map[string]interface{}{
+			"path":     strings.Join(pathVars, "/"),
+			"key":      key,
+			"expected": selects.Kind,
+			"actual":   val,
+		}
+		if !rulePassed && allow {
+			errDetails["rule_type"] = "allow"
+			errs = append(errs, NewGatekeeperError("Broken SELECTS() rule: \n%v", errDetails))
+		} else if rulePassed && !allow {
+			errDetails["rule_type"] = "deny"
+			errs = append(errs, NewGatekeeperError("Broken SELECTS() rule: \n%v", errDetails))
+		}
 	case "tag":
-		var tag TAG
-		if err := mapstructure.Decode(rule, &tag); err != nil {
-			errs = append(errs, err)
+		tag := f.args.(*TAG)
+		if !isScalar(val) {
+			errs = append(errs, typeMismatch("TAG", scalarType, val, pathVars, key, allow))
 			return errs
 		}
 		resourceVal := fmt.Sprintf("%v", val)
@@ -342,7 +1124,7 @@ func applyRule(rule map[string]interface{}, key string, val interface{}, pathVar
 			}
 			if !rulePassed && allow {
 				errDetails["rule_type"] = "allow"
-				errs = append(errs, NewGatekeeperError("Broken TAG() rule: \n%v", errDetails))
+				errs = append(errs, withFix(NewGatekeeperError("Broken TAG() rule: \n%v", errDetails), tag.Fix, key, val))
 			} else if rulePassed && !allow {
 				errDetails["rule_type"] = "deny"
 				errs = append(errs, NewGatekeeperError("Broken TAG() rule: \n%v", errDetails))
@@ -351,9 +1133,9 @@ func applyRule(rule map[string]interface{}, key string, val interface{}, pathVar
 			tagMap[tag.Tag] = resourceVal
 		}
 	case "path":
-		var path PATH
-		if err := mapstructure.Decode(rule, &path); err != nil {
-			errs = append(errs, err)
+		path := f.args.(*PATH)
+		if !isScalar(val) {
+			errs = append(errs, typeMismatch("PATH", scalarType, val, pathVars, key, allow))
 			return errs
 		}
 		resourceVal := fmt.Sprintf("%v", val)
@@ -376,78 +1158,229 @@ func applyRule(rule map[string]interface{}, key string, val interface{}, pathVar
 		}
 		if !rulePassed && allow {
 			errDetails["rule_type"] = "allow"
-			errs = append(errs, NewGatekeeperError("Broken PATH() rule: \n%v", errDetails))
+			errs = append(errs, withFix(NewGatekeeperError("Broken PATH() rule: \n%v", errDetails), path.Fix, key, val))
 		} else if rulePassed && !allow {
 			errDetails["rule_type"] = "deny"
 			errs = append(errs, NewGatekeeperError("Broken PATH() rule: \n%v", errDetails))
 		}
 	default:
-		errs = append(errs, fmt.Errorf("Unknown gatekeeper operation encountered: %v", rule["operation"]))
+		errs = append(errs, fmt.Errorf("Unknown gatekeeper operation encountered: %v", f.operation))
 	}
 	return errs
 }
 
-// Checks if gatekeeper function is satisfied, returns boolean result of check
+// Attaches a fix that sets the key to value to the violation of a function that declares fixes
+func withFix(err error, fix bool, key string, value interface{}) error {
+	if v, ok := err.(*Violation); ok && fix {
+		v.Fix = &Fix{Key: key, Value: value}
+	}
+	return err
+}
+
+// Checks if gatekeeper function is satisfied, returns boolean result of check and the type mismatches of the
+// functions that could not be applied to the value, which are reported instead of the result
 // TODO: return a list of errors so that you can see what caused an AND(), OR(), or NOT() rule to fail
-func checkRule(gFunction map[string]interface{}, val interface{}, pathVars []string, tagMap map[string]string) bool {
-	switch gFunction["operation"] {
+func checkRule(f *function, key string, val interface{}, pathVars []string, tagMap map[string]string, scope *namespaceScope, allow bool) (bool, []error) {
+	// A missing key is not a type mismatch, the functions that do not check presence are not satisfied by it
+	mismatch := func(expectedType string) (bool, []error) {
+		if _, missing := val.(missingKey); missing {
+			return false, nil
+		}
+		return false, []error{typeMismatch(f.name, expectedType, val, pathVars, key, allow)}
+	}
+
+	switch f.operation {
 	case "&":
-		var and AND
-		mapstructure.Decode(gFunction, &and)
-		return checkRule(and.Op1, val, pathVars, tagMap) && checkRule(and.Op2, val, pathVars, tagMap)
+		passed1, mismatches1 := checkRule(f.operands[0], key, val, pathVars, tagMap, scope, allow)
+		passed2, mismatches2 := checkRule(f.operands[1], key, val, pathVars, tagMap, scope, allow)
+		if passed1 && passed2 {
+			return true, nil
+		}
+		return false, append(mismatches1, mismatches2...)
 	case "|":
-		var or OR
-		mapstructure.Decode(gFunction, &or)
-		return checkRule(or.Op1, val, pathVars, tagMap) || checkRule(or.Op2, val, pathVars, tagMap)
+		passed1, mismatches1 := checkRule(f.operands[0], key, val, pathVars, tagMap, scope, allow)
+		passed2, mismatches2 := checkRule(f.operands[1], key, val, pathVars, tagMap, scope, allow)
+		if passed1 || passed2 {
+			return true, nil
+		}
+		return false, append(mismatches1, mismatches2...)
 	case "!":
-		var not NOT
-		mapstructure.Decode(gFunction, &not)
-		return !checkRule(not.Op, val, pathVars, tagMap)
+		passed, mismatches := checkRule(f.operands[0], key, val, pathVars, tagMap, scope, allow)
+		if len(mismatches) > 0 {
+			return false, mismatches
+		}
+		return !passed, nil
 	case ">":
-		var gt GT
-		mapstructure.Decode(gFunction, &gt)
-		val, _ := val.(float64)
-		return val > gt.Value
+		gt := f.args.(*GT)
+		val, ok := toNumber(val, gt.Coerce)
+		if !ok {
+			return mismatch("number")
+		}
+		return val > gt.Value, nil
 	case "<":
-		var lt LT
-		mapstructure.Decode(gFunction, &lt)
-		val, _ := val.(float64)
-		return val < lt.Value
+		lt := f.args.(*LT)
+		val, ok := toNumber(val, lt.Coerce)
+		if !ok {
+			return mismatch("number")
+		}
+		return val < lt.Value, nil
 	case "=":
-		var eq EQ
-		mapstructure.Decode(gFunction, &eq)
+		eq := f.args.(*EQ)
+		if !isScalar(val) {
+			return mismatch(scalarType)
+		}
 		val := fmt.Sprintf("%v", val)
 		eqVal := fmt.Sprintf("%v", eq.Value)
-		return val == eqVal
+		return val == eqVal, nil
+	case "qlt":
+		limit := f.quantities[0]
+		resourceVal, err := parseQuantity(val)
+		if err != nil {
+			return mismatch("quantity")
+		}
+		return resourceVal.Cmp(limit) < 0, nil
+	case "qgt":
+		limit := f.quantities[0]
+		resourceVal, err := parseQuantity(val)
+		if err != nil {
+			return mismatch("quantity")
+		}
+		return resourceVal.Cmp(limit) > 0, nil
+	case "qrange":
+		min, max := f.quantities[0], f.quantities[1]
+		resourceVal, err := parseQuantity(val)
+		if err != nil {
+			return mismatch("quantity")
+		}
+		return resourceVal.Cmp(min) >= 0 && resourceVal.Cmp(max) <= 0, nil
+	case "match", "glob":
+		if !isScalar(val) {
+			return mismatch(scalarType)
+		}
+		return f.regex.MatchString(fmt.Sprintf("%v", val)), nil
+	case "in":
+		in := f.args.(*IN)
+		if !isScalar(val) {
+			return mismatch(scalarType)
+		}
+		return containsValue(in.Values, val), nil
+	case "notin":
+		notIn := f.args.(*NOTIN)
+		if !isScalar(val) {
+			return mismatch(scalarType)
+		}
+		return !containsValue(notIn.Values, val), nil
+	case "prefix":
+		prefix := f.args.(*PREFIX)
+		if !isScalar(val) {
+			return mismatch(scalarType)
+		}
+		return strings.HasPrefix(fmt.Sprintf("%v", val), prefix.Value), nil
+	case "suffix":
+		suffix := f.args.(*SUFFIX)
+		if !isScalar(val) {
+			return mismatch(scalarType)
+		}
+		return strings.HasSuffix(fmt.Sprintf("%v", val), suffix.Value), nil
+	case "contains":
+		contains := f.args.(*CONTAINS)
+		switch val.(type) {
+		case string, []interface{}:
+		default:
+			return mismatch("string or array")
+		}
+		return containsElement(val, contains.Value), nil
+	case "exists":
+		_, missing := val.(missingKey)
+		return !missing, nil
+	case "default":
+		_, missing := val.(missingKey)
+		return !missing, nil
+	case "absent":
+		_, missing := val.(missingKey)
+		return missing, nil
+	case "optional":
+		if _, missing := val.(missingKey); missing {
+			return true, nil
+		}
+		return satisfiesValue(f.tree, val, pathVars, tagMap, scope), nil
+	case "every":
+		val, ok := val.([]interface{})
+		if !ok {
+			return mismatch("array")
+		}
+		for _, element := range val {
+			if !satisfiesValue(f.tree, element, pathVars, tagMap, scope) {
+				return false, nil
+			}
+		}
+		return true, nil
+	case "some":
+		val, ok := val.([]interface{})
+		if !ok {
+			return mismatch("array")
+		}
+		for _, element := range val {
+			if satisfiesValue(f.tree, element, pathVars, tagMap, scope) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "index":
+		index := f.args.(*INDEX)
+		val, ok := val.([]interface{})
+		if !ok {
+			return mismatch("array")
+		}
+		if index.Index < 0 || index.Index > len(val)-1 {
+			return false, nil
+		}
+		return satisfiesValue(f.tree, val[index.Index], pathVars, tagMap, scope), nil
+	case "ref":
+		ref := f.args.(*REF)
+		if !isScalar(val) {
+			return mismatch(scalarType)
+		}
+		return scope.exists(ref.Kind, fmt.Sprintf("%v", val)), nil
+	case "selects":
+		selects := f.args.(*SELECTS)
+		selector, ok := val.(map[string]interface{})
+		if !ok {
+			return mismatch("object")
+		}
+		return scope.selects(selects.Kind, selector), nil
 	case "tag":
-		var tag TAG
-		mapstructure.Decode(gFunction, &tag)
+		tag := f.args.(*TAG)
+		if !isScalar(val) {
+			return mismatch(scalarType)
+		}
 		val := fmt.Sprintf("%v", val)
 		if tagVal, ok := tagMap[tag.Tag]; ok {
-			return val == tagVal
+			return val == tagVal, nil
 		}
-		return true
+		return true, nil
 	case "path":
-		var path PATH
-		mapstructure.Decode(gFunction, &path)
+		path := f.args.(*PATH)
+		if !isScalar(val) {
+			return mismatch(scalarType)
+		}
 		if path.Index > len(pathVars)-1 {
-			return false
+			return false, nil
 		}
 		pathVal := pathVars[len(pathVars)-1-path.Index]
 		val := fmt.Sprintf("%v", val)
-		return val == pathVal
+		return val == pathVal, nil
 	default:
-		return false
+		return false, nil
 	}
 }
 
-// verifyStructure verifies structural rules
-func verifyStructure(path string) []error {
+// verifyStructure verifies structural rules, resourceIds records the resources seen so far to detect duplicates
+func verifyStructure(path string, resources []map[string]interface{}, resourceIds map[ResourceIdentifier]bool) []error {
 	errs := []error{}
 
 	//Parse path variables
 	pathVars := strings.Split(path, "/")
-	resources, errs := parseFile(path)
 	for _, resource := range resources {
 
 		// Check kind exists
@@ -500,7 +1433,7 @@ func verifyStructure(path string) []error {
 					"duplicate_kind":      resource["kind"],
 					"resource":            resource,
 				}
-				errs = append(errs, NewGatekeeperError("Duplicate resource with same namespace, name, and kind: \n%v", errDetails))
+				errs = append(errs, identifyViolations(resourceID, []error{NewGatekeeperError("Duplicate resource with same namespace, name, and kind: \n%v", errDetails)})...)
 				continue
 			} else {
 				resourceIds[resourceID] = true
@@ -514,38 +1447,112 @@ func verifyStructure(path string) []error {
 	return errs
 }
 
-// ParseRuleset parses the ruleset file and returns a RuleSet object
-func ParseRuleset(rulesetPath string, gatekeeperFunctions string) RuleSet {
-	// Read ruleset
-	ruleSetContent, err := ioutil.ReadFile(rulesetPath)
-	if err != nil {
-		fmt.Println("Error reading " + rulesetPath + ": " + err.Error())
-		os.Exit(1)
+// NewGatekeeperError creates a new gatekeeper error from a message format and its details
+func NewGatekeeperError(errString string, errDetails map[string]interface{}) error {
+	v := &Violation{
+		Message:  strings.TrimSuffix(strings.TrimSpace(strings.TrimSuffix(errString, "%v")), ":"),
+		Severity: SeverityError,
+		Details:  errDetails,
 	}
+	if path, ok := errDetails["path"]; ok {
+		v.Path = fmt.Sprintf("%v", path)
+	}
+	if key, ok := errDetails["key"]; ok {
+		v.Key = fmt.Sprintf("%v", key)
+	}
+	if ruleType, ok := errDetails["rule_type"]; ok {
+		v.RuleType = fmt.Sprintf("%v", ruleType)
+	}
+	if expected, ok := errDetails["expected"]; ok {
+		v.Expected = expected
+	}
+	if expectedType, ok := errDetails["expected_type"]; ok {
+		v.ExpectedType = fmt.Sprintf("%v", expectedType)
+	}
+	if actualType, ok := errDetails["actual_type"]; ok {
+		v.ActualType = fmt.Sprintf("%v", actualType)
+	}
+	if actual, ok := errDetails["actual"]; ok {
+		v.Actual = actual
+	} else if value, ok := errDetails["value"]; ok {
+		v.Actual = value
+	}
+	return v
+}
 
-	// Run go-jsonnet on concatenated result of gatekeeper functions + ruleset
-	jsonnetResult := gatekeeperFunctions + string(ruleSetContent)
-	vm := jsonnet.MakeVM()
-	jsonResult, err := vm.EvaluateSnippet("<cmdline>", jsonnetResult)
+// Error formats the violation as its message followed by its indented details
+func (v *Violation) Error() string {
+	message := v.Message
+	if v.Rule != "" {
+		message = v.Rule + ": " + message
+	}
+	if v.Details == nil {
+		return message
+	}
+	b, err := json.MarshalIndent(v.Details, "", "	")
 	if err != nil {
-		fmt.Println("Error using go-jsonnet to parse ruleset: " + err.Error())
-		os.Exit(1)
+		return fmt.Sprintf("Error unmarshalling error details: \n%v", err)
 	}
+	return fmt.Sprintf("%v: \n%v", message, string(b))
+}
 
-	var ruleSet RuleSet
-	err = json.Unmarshal([]byte(jsonResult), &ruleSet)
-	if err != nil {
-		fmt.Println("Error unmarshalling ruleset json: " + err.Error())
-		os.Exit(1)
+// Fails returns whether the violation is at least as severe as the given severity
+func (v *Violation) Fails(severity string) bool {
+	return severityLevels[v.Severity] >= severityLevels[severity]
+}
+
+// ToViolation converts an error returned by Verify into a Violation
+func ToViolation(err error) *Violation {
+	if v, ok := err.(*Violation); ok {
+		return v
 	}
-	return ruleSet
+	return &Violation{Message: err.Error(), Severity: SeverityError}
 }
 
-// NewGatekeeperError creates a new gatekeeper error and appends to the given errors slice
-func NewGatekeeperError(errString string, errDetails map[string]interface{}) error {
-	b, err := json.MarshalIndent(errDetails, "", "	")
-	if err != nil {
-		return fmt.Errorf("Error unmarshalling error details: \n%v", err)
+// ValidSeverity returns whether severity is a known severity
+func ValidSeverity(severity string) bool {
+	_, ok := severityLevels[severity]
+	return ok
+}
+
+// Attaches the name, description, severity, docs and templated message of a rule to its violations
+func annotateViolations(rule *compiledRule, errs []error) []error {
+	for i, err := range errs {
+		v, ok := err.(*Violation)
+		if !ok {
+			v = ToViolation(err)
+			errs[i] = v
+		}
+		v.rule = rule
+		v.Rule = rule.Name
+		v.Description = rule.Description
+		v.Docs = rule.Docs
+		v.Source = rule.Source
+		if ValidSeverity(rule.Severity) {
+			v.Severity = rule.Severity
+		}
+		if rule.message != nil {
+			v.Message = renderMessage(rule, v)
+		}
+	}
+	return errs
+}
+
+// Attaches the identity of the resource they were found in to violations
+func identifyViolations(id ResourceIdentifier, errs []error) []error {
+	for i, err := range errs {
+		v := ToViolation(err)
+		v.Resource = &id
+		errs[i] = v
+	}
+	return errs
+}
+
+// Renders the message template of a rule with the fields of a violation, e.g. "{{.Key}} must be less than {{.Expected}}"
+func renderMessage(rule *compiledRule, v *Violation) string {
+	var b bytes.Buffer
+	if err := rule.message.Execute(&b, v); err != nil {
+		return rule.Message
 	}
-	return fmt.Errorf(errString, string(b))
+	return b.String()
 }
@@ -1,15 +1,19 @@
 package verifier
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
+	"testing/fstest"
 
-	"github.com/gobuffalo/packr"
+	"github.com/wish/gatekeeper/parser"
 )
 
 type CheckRuleArgObj struct {
@@ -30,6 +34,14 @@ type ApplyRuleArgObj struct {
 	FullError  []string
 }
 
+type VerifyResourcesArgObj struct {
+	Rule       Rule
+	Resources  []map[string]interface{}
+	PathVars   []string
+	Exemptions []Exemption
+	Violations []Violation
+}
+
 type VerifyArgObj struct {
 	Result     []string
 	ErrDetails []map[string]interface{}
@@ -42,20 +54,60 @@ var checkRuleTestFile = "test_files/verifier_test_check_rule.json"
 var applyRuleTestFile = "test_files/verifier_test_apply_rule.json"
 var parseRulesetTestJsonnet = "test_files/verifier_test_parse_ruleset.jsonnet"
 var parseRulesetTestFile = "test_files/verifier_test_parse_ruleset.json"
+var parseFileTestYaml = "test_files/verifier_test_parse_file.yaml"
+var parseFileTestFile = "test_files/verifier_test_parse_file.json"
+var verifyResourcesTestFile = "test_files/verifier_test_verify_resources.json"
 
-func TestVerify(t *testing.T) {
-	//Parse ruleset
+// Reads the ruleset of parseRulesetTestFile
+func readTestRuleSet(t *testing.T) RuleSet {
 	var ruleSet RuleSet
 	ruleSetRaw, err := ioutil.ReadFile(parseRulesetTestFile)
 	if err != nil {
-		t.Errorf("Cannot read ruleset file %v", parseRulesetTestFile)
-		return
+		t.Fatalf("Cannot read ruleset file %v", parseRulesetTestFile)
 	}
-	err = json.Unmarshal(ruleSetRaw, &ruleSet)
+	if err := json.Unmarshal(ruleSetRaw, &ruleSet); err != nil {
+		t.Fatalf("Error when unmarshalling ruleset file %v: %v", parseRulesetTestFile, err)
+	}
+	return ruleSet
+}
+
+// Reads a jsonnet ruleset with the gatekeeper functions and compiles it, the ruleset must be valid
+func compileTestRuleset(t *testing.T, ruleset string) (RuleSet, *CompiledRuleSet) {
+	gatekeeperFunctions, err := GatekeeperFunctions()
 	if err != nil {
-		t.Errorf("Error when unmarshalling ruleset file %v: %v", parseRulesetTestFile, err)
-		return
+		t.Fatalf("Error getting gatekeeper functions: %v", err)
+	}
+	ruleSet, err := ReadRuleset(strings.NewReader(ruleset), gatekeeperFunctions)
+	if err != nil {
+		t.Fatalf("Error reading ruleset: %v", err)
+	}
+	return ruleSet, compileRuleSet(t, ruleSet)
+}
+
+// Compiles a ruleset that must be valid
+func compileRuleSet(t *testing.T, ruleSet RuleSet) *CompiledRuleSet {
+	compiled, errs := Compile(ruleSet)
+	if len(errs) > 0 {
+		t.Fatalf("Error compiling ruleset: %v", errs)
+	}
+	return compiled
+}
+
+// Compiles a ruleset and verifies a folder with it, returns the errors of invalid rules followed by the violations
+func verifyFolder(ruleSet RuleSet, base string) []error {
+	compiled, errs := Compile(ruleSet)
+	report, err := compiled.VerifyDir(base, 0)
+	for _, v := range report.Violations {
+		errs = append(errs, v)
 	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+func TestVerify(t *testing.T) {
+	ruleSet := readTestRuleSet(t)
 
 	//Parse expected results
 	var expectedResults VerifyArgObj
@@ -76,7 +128,7 @@ func TestVerify(t *testing.T) {
 		expectedResults.FullError = append(expectedResults.FullError, fullErrString)
 	}
 
-	result := Verify(ruleSet, verifyTestFolder)
+	result := verifyFolder(ruleSet, verifyTestFolder)
 	if len(result) != len(expectedResults.FullError) {
 		t.Errorf("Expected \n%v\nbut got \n%v\nwhen verifying %v with ruleset %v", expectedResults.FullError, result, verifyTestFolder, parseRulesetTestFile)
 	} else {
@@ -96,20 +148,543 @@ func TestVerify(t *testing.T) {
 	}
 }
 
+func TestVerifyDir(t *testing.T) {
+	compiled := compileRuleSet(t, readTestRuleSet(t))
+
+	// The report must not depend on the number of concurrent jobs
+	expected, err := compiled.VerifyDir(verifyTestFolder, 1)
+	if err != nil {
+		t.Errorf("Error verifying %v: %v", verifyTestFolder, err)
+		return
+	}
+	for i := 0; i < 5; i++ {
+		report, err := compiled.VerifyDir(verifyTestFolder, 8)
+		if err != nil {
+			t.Errorf("Error verifying %v: %v", verifyTestFolder, err)
+			return
+		}
+		if !reflect.DeepEqual(report, expected) {
+			t.Errorf("Expected \n%v\nbut got \n%v\nwhen verifying %v with 8 jobs", expected.Violations, report.Violations, verifyTestFolder)
+			return
+		}
+	}
+
+	if _, err := compiled.VerifyDir("test_files/missing", 1); err == nil {
+		t.Errorf("Expected an error when verifying a missing folder")
+	}
+}
+
+func TestVerifyFS(t *testing.T) {
+	compiled := compileRuleSet(t, readTestRuleSet(t))
+
+	// The files of a file system are verified like the files of the folder, at paths relative to its root
+	root := filepath.Dir(verifyTestFolder)
+	expected, err := compiled.VerifyDir(root, 1)
+	if err != nil {
+		t.Fatalf("Error verifying %v: %v", root, err)
+	}
+	report, err := compiled.VerifyFS(os.DirFS(root), 4)
+	if err != nil {
+		t.Fatalf("Error verifying the file system of %v: %v", root, err)
+	}
+	if len(report.Violations) == 0 || len(report.Violations) != len(expected.Violations) {
+		t.Fatalf("Expected \n%v\nbut got \n%v\nwhen verifying the file system of %v", expected.Violations, report.Violations, root)
+	}
+	for i, v := range report.Violations {
+		if root+"/"+v.Path != expected.Violations[i].Path || v.Rule != expected.Violations[i].Rule {
+			t.Errorf("Expected \n%v\nbut got \n%v\nwhen verifying the file system of %v", expected.Violations[i], v, root)
+		}
+	}
+
+	report, err = compiled.VerifyFS(fstest.MapFS{
+		"web/deployment.yaml": {Data: []byte("kind: [")},
+	}, 1)
+	if err != nil || len(report.Violations) != 1 || !strings.Contains(report.Violations[0].Message, "Could not parse web/deployment.yaml") {
+		t.Errorf("Expected a parse error of web/deployment.yaml, got %v and %v", report.Violations, err)
+	}
+}
+
+func TestVerifyChanged(t *testing.T) {
+	ruleSet := readTestRuleSet(t)
+	// References of a changed file must still resolve against the resources of unchanged files
+	ruleSet.References = []string{ReferenceSecrets, ReferenceConfigMaps}
+	compiled := compileRuleSet(t, ruleSet)
+
+	full, err := compiled.VerifyDir(verifyTestFolder, 1)
+	if err != nil {
+		t.Fatalf("Error verifying %v: %v", verifyTestFolder, err)
+	}
+	sample := verifyTestFolder + "/sample.json"
+	namespace := verifyTestFolder + "/_namespace.json"
+	for _, changed := range [][]string{
+		{sample, "test_files/missing.yaml"},
+		{namespace},
+		{sample, namespace},
+		{},
+	} {
+		// Only the violations of the changed files are reported, changed files that are not in the folder are skipped
+		expected := []*Violation{}
+		for _, v := range full.Violations {
+			for _, path := range changed {
+				if v.Path == path {
+					expected = append(expected, v)
+				}
+			}
+		}
+		report, err := compiled.VerifyInputs(Inputs{Paths: []string{verifyTestFolder}, Changed: changed}, 4)
+		if err != nil {
+			t.Errorf("Error verifying changed files %v: %v", changed, err)
+		} else if !reflect.DeepEqual(report.Violations, expected) {
+			t.Errorf("Expected \n%v\nbut got \n%v\nwhen only %v changed", expected, report.Violations, changed)
+		}
+	}
+}
+
+func TestVerifyInputs(t *testing.T) {
+	compiled := compileRuleSet(t, readTestRuleSet(t))
+
+	full, err := compiled.VerifyDir(verifyTestFolder, 1)
+	if err != nil {
+		t.Fatalf("Error verifying %v: %v", verifyTestFolder, err)
+	}
+
+	// A file that is also in a given folder is verified once
+	report, err := compiled.VerifyInputs(Inputs{Paths: []string{verifyTestFolder + "/sample.json", verifyTestFolder}}, 4)
+	if err != nil {
+		t.Fatalf("Error verifying inputs: %v", err)
+	}
+	if len(report.Violations) != len(full.Violations) {
+		t.Errorf("Expected \n%v\nbut got \n%v\nwhen a file is also given on its own", full.Violations, report.Violations)
+	}
+
+	// Resources that are not read from files are checked for duplicates against the files
+	content, err := ioutil.ReadFile(verifyTestFolder + "/sample.json")
+	if err != nil {
+		t.Fatalf("Cannot read %v: %v", verifyTestFolder+"/sample.json", err)
+	}
+	resources, err := parser.ParseResources(content)
+	if err != nil {
+		t.Fatalf("Cannot parse %v: %v", verifyTestFolder+"/sample.json", err)
+	}
+	report, err = compiled.VerifyInputs(Inputs{
+		Paths:     []string{verifyTestFolder},
+		Resources: map[string][]map[string]interface{}{"stdin.yaml": resources[:1]},
+		Changed:   []string{},
+	}, 4)
+	if err != nil {
+		t.Fatalf("Error verifying inputs: %v", err)
+	}
+	if len(report.Violations) == 0 {
+		t.Fatalf("Expected a duplicate resource violation of stdin.yaml")
+	}
+	for _, v := range report.Violations {
+		if v.Path != "stdin.yaml" {
+			t.Errorf("Expected only violations of stdin.yaml when no file changed, got %v", v)
+		}
+	}
+	if !strings.Contains(report.Violations[0].Message, "Duplicate resource") {
+		t.Errorf("Expected a duplicate resource violation of stdin.yaml, got %v", report.Violations[0])
+	}
+
+	if _, err := compiled.VerifyInputs(Inputs{Paths: []string{"test_files/missing"}}, 1); err == nil {
+		t.Errorf("Expected an error when a path does not exist")
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	compiled := compileRuleSet(t, readTestRuleSet(t))
+	report, err := compiled.VerifyDir(verifyTestFolder, 1)
+	if err != nil || len(report.Violations) == 0 {
+		t.Fatalf("Expected violations in %v, got %v and %v", verifyTestFolder, report.Violations, err)
+	}
+
+	// Fingerprints do not depend on the path of the file
+	v := *report.Violations[0]
+	moved := v
+	moved.Path = "moved/" + v.Path
+	if v.Resource == nil || v.Fingerprint() != moved.Fingerprint() {
+		t.Errorf("Expected the fingerprint of %v to be stable when its file moves", v)
+	}
+
+	var b bytes.Buffer
+	if err := NewBaseline(report).Write(&b); err != nil {
+		t.Fatalf("Error writing baseline: %v", err)
+	}
+	baseline, err := ReadBaseline(&b)
+	if err != nil {
+		t.Fatalf("Error reading baseline: %v", err)
+	}
+
+	// Recorded violations are suppressed and nothing is fixed
+	rerun, _ := compiled.VerifyDir(verifyTestFolder, 1)
+	if fixed := baseline.Apply(rerun); len(fixed) != 0 || len(rerun.Unsuppressed()) != 0 {
+		t.Errorf("Expected every violation to be in the baseline, got %v new and %v fixed", rerun.Unsuppressed(), fixed)
+	}
+	for _, v := range rerun.Violations {
+		if v.Suppression != BaselineSuppression {
+			t.Errorf("Expected violation to be suppressed by the baseline, got %v", v.Suppression)
+		}
+	}
+
+	// Entries that no longer occur are fixed
+	if fixed := baseline.Apply(Report{}); len(fixed) != len(baseline.Entries) {
+		t.Errorf("Expected every baseline entry to be fixed, got %v", fixed)
+	}
+}
+
+func TestBaselineUnnamedRules(t *testing.T) {
+	_, compiled := compileTestRuleset(t, `{
+		rules: [
+			{ regex: ".*", kind: "Deployment", type: "allow", ruleTree: { spec: { replicas: LT(5) } }, message: "{{.Actual}} replicas" },
+			{ regex: ".*", kind: "Deployment", type: "allow", ruleTree: { spec: { replicas: LT(3) } }, message: "{{.Actual}} replicas" },
+		],
+	}`)
+	verify := func(replicas int) Report {
+		content := fmt.Sprintf(`{"kind": "Deployment", "metadata": {"name": "web"}, "spec": {"replicas": %v}}`, replicas)
+		report, err := compiled.VerifyReader("web/deployment.json", strings.NewReader(content))
+		if err != nil || len(report.Violations) != 2 {
+			t.Fatalf("Expected 2 violations, got %v and %v", report.Violations, err)
+		}
+		return report
+	}
+
+	// Unnamed rules are told apart by their position, and changing the value does not change the fingerprints
+	report := verify(10)
+	if report.Violations[0].Fingerprint() == report.Violations[1].Fingerprint() {
+		t.Errorf("Expected violations of different unnamed rules to have different fingerprints")
+	}
+	baseline := NewBaseline(report)
+	rerun := verify(20)
+	if fixed := baseline.Apply(rerun); len(fixed) != 0 || len(rerun.Unsuppressed()) != 0 {
+		t.Errorf("Expected the violations to be in the baseline after their message changed, got %v new and %v fixed", rerun.Unsuppressed(), fixed)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	ruleSet := RuleSet{
+		Rules: []Rule{
+			{Name: "valid", Regex: ".*", Kind: "Deployment", Type: "allow", RuleTree: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"replicas": map[string]interface{}{"gatekeeper": true, "operation": "<", "value": 10},
+				},
+			}},
+			{Name: "bad-regex", Regex: "(", Kind: "Deployment", Type: "allow"},
+			{Name: "bad-function", Regex: ".*", Kind: "Deployment", Type: "deny", RuleTree: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"replicas": map[string]interface{}{
+						"gatekeeper": true,
+						"operation":  "&",
+						"op1":        map[string]interface{}{"gatekeeper": true, "operation": "asdf"},
+						"op2":        map[string]interface{}{"gatekeeper": true, "operation": "match", "regex": "("},
+					},
+				},
+			}},
+			{Name: "bad-message", Regex: ".*", Kind: "Deployment", Type: "allow", Message: "{{.Key is invalid"},
+		},
+	}
+
+	compiled, errs := Compile(ruleSet)
+	if len(errs) != 4 || len(compiled.rules) != 1 || compiled.rules[0].Name != "valid" {
+		t.Errorf("Expected 4 errors and only the valid rule to be compiled, got %v and %v rules", errs, len(compiled.rules))
+		return
+	}
+	for i, rule := range []string{"bad-regex", "bad-function", "bad-function", "bad-message"} {
+		if v := ToViolation(errs[i]); v.Rule != rule {
+			t.Errorf("Expected error %v to be for rule %v, got %v", i, rule, v)
+		}
+	}
+
+	// Invalid rules are reported once, not for every file they apply to
+	count := 0
+	for _, err := range verifyFolder(ruleSet, verifyTestFolder) {
+		if v := ToViolation(err); v.Rule == "bad-regex" || v.Rule == "bad-function" || v.Rule == "bad-message" {
+			count++
+		}
+	}
+	if count != 4 {
+		t.Errorf("Expected 4 errors for invalid rules when verifying %v, got %v", verifyTestFolder, count)
+	}
+}
+
+func TestLintRuleset(t *testing.T) {
+	gatekeeperFunctions, err := GatekeeperFunctions()
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	f, err := os.Open("test_files/verifier_test_lint_ruleset.jsonnet")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	defer f.Close()
+	report, err := LintRuleset(f, gatekeeperFunctions)
+	if err != nil {
+		t.Fatalf("Error linting ruleset: %v", err)
+	}
+
+	expected := []struct {
+		Rule     string
+		Severity string
+		Message  string
+	}{
+		{"", SeverityError, "Unknown field in Rule"},
+		{"", SeverityError, "Unknown field in Match"},
+		{"", SeverityError, "Exemption must have at least one of the rules, namespace, name, kind or path fields"},
+		{"replica-limit", SeverityError, "Invalid type field in rule (must be allow or deny)"},
+		{"namespace-name", SeverityError, "PATH() index must not be negative"},
+		{"namespace-name", SeverityWarning, "Rule regex never matches, it is matched against file names which do not contain /"},
+		{"widget-owner", SeverityWarning, "Rule kind is not a built-in Kubernetes kind"},
+		{"widget-owner", SeverityError, "Rule is missing the regex field"},
+		{"widget-owner", SeverityWarning, "Allow rule has an empty ruleTree and never reports a violation"},
+		{"widget-owner", SeverityWarning, "Duplicate rule name"},
+		{"pod-team", SeverityWarning, "Rule exclude block is empty and excludes every resource"},
+		{"", SeverityWarning, "Exemption refers to a rule that does not exist"},
+	}
+	if len(report.Violations) != len(expected) {
+		t.Fatalf("Expected %v findings, got %v", len(expected), report.Violations)
+	}
+	for i, v := range report.Violations {
+		if v.Rule != expected[i].Rule || v.Severity != expected[i].Severity || v.Message != expected[i].Message {
+			t.Errorf("Expected finding %v to be %v, got %v", i, expected[i], v)
+		}
+	}
+	if !report.Fails(SeverityError) {
+		t.Errorf("Expected lint report to fail")
+	}
+}
+
+func TestRunTests(t *testing.T) {
+	ruleSet := RuleSet{
+		Rules: []Rule{
+			{Name: "replica-limit", Regex: ".*", Kind: "Deployment", Type: "allow", RuleTree: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"replicas": map[string]interface{}{"gatekeeper": true, "operation": "<", "value": 25},
+				},
+			}},
+			{Regex: ".*", Kind: "Service", Type: "deny"},
+		},
+	}
+	compiled, errs := Compile(ruleSet)
+	if len(errs) > 0 {
+		t.Fatalf("Could not compile test ruleset: %v", errs)
+	}
+
+	files, err := FindTests("test_files")
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected to find one test file, got %v and %v", files, err)
+	}
+	testCases, err := ReadTests(files[0])
+	if err != nil {
+		t.Fatalf("Error reading test cases: %v", err)
+	}
+	results := compiled.RunTests(files[0], testCases)
+	if len(results) != 5 {
+		t.Fatalf("Expected 5 results, got %v", results)
+	}
+	for i, passed := range []bool{true, true, false, false, false} {
+		if results[i].Passed() != passed {
+			t.Errorf("Expected test case %v to pass: %v, got %v", results[i].Name, passed, results[i])
+		}
+	}
+	if len(results[2].Diffs) != 1 || results[2].Diffs[0] != "replica-limit:\n- Broken LT() rule" {
+		t.Errorf("Expected a diff of the missing violation, got %v", results[2].Diffs)
+	}
+	if results[3].Err == nil {
+		t.Errorf("Expected an error for a test case of an unknown rule")
+	}
+	if results[4].Err == nil || !strings.Contains(results[4].Err.Error(), "unnamed rule") {
+		t.Errorf("Expected an error for a test case of an unnamed rule, got %v", results[4].Err)
+	}
+}
+
 func TestVerifyFileWithRule(t *testing.T) {
+	rule, errs := compileRule(Rule{Name: "namespace", Regex: ".*", Kind: "Deployment", Type: "allow", RuleTree: map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"namespace": map[string]interface{}{"gatekeeper": true, "operation": "path", "index": 1},
+		},
+	}})
+	if len(errs) > 0 {
+		t.Fatalf("Unexpected errors compiling rule: %v", errs)
+	}
+	resource := func(kind string, namespace string, name string) map[string]interface{} {
+		return map[string]interface{}{"kind": kind, "metadata": map[string]interface{}{"namespace": namespace, "name": name}}
+	}
 
+	testCases := []struct {
+		path      string
+		resources []map[string]interface{}
+		expected  []string
+	}{
+		{"web/deployment.yaml", []map[string]interface{}{resource("Deployment", "web", "frontend")}, []string{}},
+		// Path variables come from the path of the file, violations are identified by their resource
+		{"web/deployment.yaml", []map[string]interface{}{resource("Deployment", "web", "frontend"), resource("Deployment", "api", "backend")}, []string{
+			"namespace api/backend metadata.namespace: Broken PATH() rule",
+		}},
+		{"api/deployment.yaml", []map[string]interface{}{resource("Deployment", "web", "frontend"), resource("Deployment", "api", "backend")}, []string{
+			"namespace web/frontend metadata.namespace: Broken PATH() rule",
+		}},
+		// Resources of other kinds are not verified
+		{"web/service.yaml", []map[string]interface{}{resource("Service", "api", "backend")}, []string{}},
+	}
+	for _, testCase := range testCases {
+		result := verifyFileWithRule(testCase.path, testCase.resources, rule, nil, newResourceIndex(testCase.resources))
+		violations := []string{}
+		for _, err := range result {
+			v := ToViolation(err)
+			violations = append(violations, fmt.Sprintf("%v %v/%v %v: %v", v.Rule, v.Resource.Namespace, v.Resource.Name, v.Key, v.Message))
+		}
+		if !reflect.DeepEqual(violations, testCase.expected) {
+			t.Errorf("Expected \n%v\nbut got \n%v\nwhen verifying %v", testCase.expected, violations, testCase.path)
+		}
+	}
 }
 
 func TestParseFile(t *testing.T) {
+	var expected []map[string]interface{}
+	expectedRaw, err := ioutil.ReadFile(parseFileTestFile)
+	if err != nil {
+		t.Errorf("Cannot read test file %v", parseFileTestFile)
+		return
+	}
+	err = json.Unmarshal(expectedRaw, &expected)
+	if err != nil {
+		t.Errorf("Error when unmarshalling test file %v: %v", parseFileTestFile, err)
+		return
+	}
 
+	file := loadFile(parseFileTestYaml, ioutil.ReadFile)
+	if file.err != nil {
+		t.Errorf("Expected no errors, got %v when parsing test file: %v", file.err, parseFileTestYaml)
+	}
+	if !reflect.DeepEqual(file.resources, expected) {
+		t.Errorf("Expected %v, got %v when parsing test file: %v", expected, file.resources, parseFileTestYaml)
+	}
+
+	if file := loadFile("test_files/missing.yaml", ioutil.ReadFile); file.err == nil {
+		t.Errorf("Expected an error when parsing a missing file")
+	}
 }
 
 func TestVerifyResources(t *testing.T) {
+	var testCases = make([]VerifyResourcesArgObj, 0)
+	testCasesRaw, err := ioutil.ReadFile(verifyResourcesTestFile)
+	if err != nil {
+		t.Errorf("Cannot read test file %v", verifyResourcesTestFile)
+		return
+	}
+	err = json.Unmarshal(testCasesRaw, &testCases)
+	if err != nil {
+		t.Errorf("Error when unmarshalling test file %v: %v", verifyResourcesTestFile, err)
+		return
+	}
 
+	for _, testCase := range testCases {
+		rule, result := compileRule(testCase.Rule)
+		exemptions, exemptionErrs := compileExemptions(testCase.Exemptions)
+		result = append(result, exemptionErrs...)
+		if len(result) == 0 {
+			result = verifyResources(rule, testCase.Resources, testCase.PathVars, make(map[string]string), exemptions, newResourceIndex(testCase.Resources))
+		}
+		if len(result) != len(testCase.Violations) {
+			t.Errorf("Expected \n%v\nbut got \n%v\nwhen running this test case: %v", testCase.Violations, result, testCase)
+			continue
+		}
+		for i, err := range result {
+			v := ToViolation(err)
+			expected := testCase.Violations[i]
+			if v.Rule != expected.Rule || v.Description != expected.Description || v.Message != expected.Message ||
+				v.Severity != expected.Severity || v.Docs != expected.Docs || v.Function != expected.Function || v.Key != expected.Key ||
+				v.Suppressed != expected.Suppressed || v.Suppression != expected.Suppression {
+				t.Errorf("Expected \n%+v\nbut got \n%+v\nwhen running this test case: %v", expected, *v, testCase)
+			}
+		}
+	}
 }
 
 func TestVerifyResourcesTraverseHelper(t *testing.T) {
+	lt := func(value int) map[string]interface{} {
+		return map[string]interface{}{"gatekeeper": true, "operation": "<", "value": value}
+	}
+	testCases := []struct {
+		ruleTree map[string]interface{}
+		resource map[string]interface{}
+		expected []string
+	}{
+		// Missing keys are reported, unless the rule checks for their presence
+		{
+			map[string]interface{}{
+				"spec": map[string]interface{}{
+					"replicas": lt(5),
+					"paused":   map[string]interface{}{"gatekeeper": true, "operation": "absent"},
+					"selector": map[string]interface{}{"gatekeeper": true, "operation": "exists"},
+				},
+			},
+			map[string]interface{}{"spec": map[string]interface{}{}},
+			[]string{
+				"spec.replicas: Resource does not have expected key",
+				"spec.selector: Broken EXISTS() rule",
+			},
+		},
+		// Objects of the rule tree are only applied to objects
+		{
+			map[string]interface{}{"spec": map[string]interface{}{"template": map[string]interface{}{"replicas": lt(5)}}},
+			map[string]interface{}{"spec": map[string]interface{}{"template": "web"}},
+			[]string{"spec.template: Expected object, but key does not contain an object for a value"},
+		},
+		// Arrays of the rule tree are matched positionally, with the index in the key
+		{
+			map[string]interface{}{"ports": []interface{}{lt(100), lt(200), lt(300)}},
+			map[string]interface{}{"ports": []interface{}{50, 250}},
+			[]string{
+				"ports[1]: Broken LT() rule",
+				"ports: Resource does not have expected index",
+			},
+		},
+		{
+			map[string]interface{}{"ports": []interface{}{lt(100)}},
+			map[string]interface{}{"ports": 50},
+			[]string{"ports: Expected array, but key does not contain an array for a value"},
+		},
+	}
+	for _, testCase := range testCases {
+		tree, errs := compileObject(testCase.ruleTree, "", false)
+		if len(errs) > 0 {
+			t.Errorf("Unexpected errors compiling rule tree %v: %v", testCase.ruleTree, errs)
+			continue
+		}
+		violations := []string{}
+		for _, err := range verifyResourcesTraverseHelper(tree, testCase.resource, []string{"web", "deployment.yaml"}, map[string]string{}, nil, nil, true) {
+			v := ToViolation(err)
+			violations = append(violations, v.Key+": "+v.Message)
+		}
+		if !reflect.DeepEqual(violations, testCase.expected) {
+			t.Errorf("Expected \n%v\nbut got \n%v\nwhen verifying %v with rule tree %v", testCase.expected, violations, testCase.resource, testCase.ruleTree)
+		}
+	}
+}
 
+func TestVerifyReferences(t *testing.T) {
+	path := verifyTestFolder + "/sample.json"
+	file := loadFile(path, ioutil.ReadFile)
+	if file.err != nil {
+		t.Errorf("Expected no errors, got %v when parsing test file: %v", file.err, path)
+		return
+	}
+	resources := file.resources
+
+	checks := []string{ReferenceSelectors, ReferenceSecrets, ReferenceConfigMaps, ReferenceServiceAccounts, ReferencePersistentVolumeClaims}
+	result := verifyReferences(path, resources, newResourceIndex(resources), checks)
+	if len(result) != 1 {
+		t.Errorf("Expected 1 error, got %v when verifying references of %v", result, path)
+		return
+	}
+	v := ToViolation(result[0])
+	if v.Key != "spec.template.spec.volumes[0].secret.secretName" || v.Details["name"] != "containerB-key" {
+		t.Errorf("Expected missing secret containerB-key, got %v", v)
+	}
+
+	if result := validateReferences([]string{"secrets", "unknown"}); len(result) != 1 {
+		t.Errorf("Expected 1 error for unknown reference check, got %v", result)
+	}
 }
 
 func TestApplyRule(t *testing.T) {
@@ -145,7 +720,10 @@ func TestApplyRule(t *testing.T) {
 		"valid_tag": "service",
 	}
 	for _, testCase := range testCases {
-		result := applyRule(testCase.Rule, testCase.Key, testCase.Val, testCase.PathVars, tagMap, testCase.Allow)
+		f, result := compileFunction(testCase.Rule, testCase.Key, false)
+		if len(result) == 0 {
+			result = applyRule(f, testCase.Key, testCase.Val, testCase.PathVars, tagMap, nil, nil, testCase.Allow)
+		}
 		if len(result) != len(testCase.Result) {
 			t.Errorf("Expected \n%v\nbut got \n%v\nwhen running this test case: %v", testCase.FullError, result, testCase)
 		} else {
@@ -183,7 +761,12 @@ func TestCheckRule(t *testing.T) {
 		"valid_tag": "service",
 	}
 	for _, testCase := range testCases {
-		result := checkRule(testCase.Rule, testCase.Val, testCase.PathVars, tagMap)
+		// Functions that do not compile are never satisfied
+		f, errs := compileFunction(testCase.Rule, "", false)
+		result := false
+		if len(errs) == 0 {
+			result, _ = checkRule(f, "", testCase.Val, testCase.PathVars, tagMap, nil, true)
+		}
 		if result != testCase.Result {
 			t.Errorf("Expected \n%v\nbut got \n%v\nwhen running this test case: %v", testCase.Result, result, testCase)
 		}
@@ -203,15 +786,417 @@ func TestParseRuleset(t *testing.T) {
 		t.Errorf("Error when unmarshalling test file %v: %v", parseRulesetTestFile, err)
 		return
 	}
+	for i := range expected.Rules {
+		expected.Rules[i].Source = parseRulesetTestJsonnet
+	}
 	// Get gatekeeper function definitions
-	box := packr.NewBox("../function_definitions")
-	gatekeeperFunctions, err := box.FindString("gatekeeper.jsonnet")
+	gatekeeperFunctions, err := GatekeeperFunctions()
 	if err != nil {
-		fmt.Println("Error: Could not get gatekeeper.jsonnet from packr.")
-		os.Exit(1)
+		t.Errorf("Error getting gatekeeper functions: %v", err)
+		return
 	}
-	result := ParseRuleset(parseRulesetTestJsonnet, gatekeeperFunctions)
-	if !reflect.DeepEqual(result, expected) {
+	result, err := ParseRuleset(parseRulesetTestJsonnet, gatekeeperFunctions)
+	if err != nil {
+		t.Errorf("Error parsing test jsonnet %v: %v", parseRulesetTestJsonnet, err)
+	} else if !reflect.DeepEqual(result, expected) {
 		t.Errorf("Expected %v, got %v when parsing test jsonnet: %v", expected, result, parseRulesetTestJsonnet)
 	}
+
+	if _, err := ParseRuleset("test_files/missing.jsonnet", gatekeeperFunctions); err == nil {
+		t.Errorf("Expected an error when parsing a missing ruleset file")
+	}
+	if _, err := ReadRuleset(strings.NewReader("{ rules: [ LT( ] }"), gatekeeperFunctions); err == nil {
+		t.Errorf("Expected an error when reading an invalid ruleset")
+	}
+}
+
+func TestLoader(t *testing.T) {
+	gatekeeperFunctions, err := GatekeeperFunctions()
+	if err != nil {
+		t.Fatalf("Error getting gatekeeper functions: %v", err)
+	}
+
+	loader := NewLoader(gatekeeperFunctions)
+	loader.LibraryPaths = []string{"test_files/verifier_test_jsonnet/lib"}
+	loader.ExtVars = map[string]string{"environment": "prod"}
+	loader.TLAVars = map[string]string{"team": "payments"}
+	ruleSet, err := loader.ParseRuleset("test_files/verifier_test_jsonnet/ruleset.jsonnet")
+	if err != nil {
+		t.Fatalf("Error parsing ruleset with imports and variables: %v", err)
+	}
+	if len(ruleSet.Rules) != 1 || ruleSet.Rules[0].Name != "payments-replica-limit" {
+		t.Fatalf("Expected the top-level argument to name the rule, got %v", ruleSet.Rules)
+	}
+	replicas := ruleSet.Rules[0].RuleTree["spec"].(map[string]interface{})["replicas"].(map[string]interface{})
+	if replicas["operation"] != "<" || replicas["value"] != 10.0 {
+		t.Errorf("Expected the imported prod limit, got %v", replicas)
+	}
+
+	// Imports that are not found in the library paths fail
+	if _, err := NewLoader(gatekeeperFunctions).ParseRuleset("test_files/verifier_test_jsonnet/ruleset.jsonnet"); err == nil {
+		t.Errorf("Expected an error for an import that is not in the library paths")
+	}
+
+	// Errors have the line numbers of the ruleset
+	_, err = ReadRuleset(strings.NewReader("{\n  rules: [\n    LT(,\n  ],\n}\n"), gatekeeperFunctions)
+	if err == nil || !strings.Contains(err.Error(), "<cmdline>:3:") {
+		t.Errorf("Expected an error on line 3, got %v", err)
+	}
+	_, err = ReadRuleset(strings.NewReader(`{ rules: error "boom" }`), gatekeeperFunctions)
+	if err == nil || !strings.Contains(err.Error(), "<cmdline>:1:10-22") {
+		t.Errorf("Expected an error on line 1 at the columns of the ruleset, got %v", err)
+	}
+
+	// A gatekeeper.libsonnet next to the ruleset can be imported, but does not replace the functions of the prelude
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "gatekeeper.libsonnet"), []byte(`{ LT(value=0, coerce=false):: "local" }`), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	rulesetPath := filepath.Join(dir, "ruleset.jsonnet")
+	ruleset := `local local_gk = import "gatekeeper.libsonnet";
+{
+  rules: [{ name: local_gk.LT(), regex: ".*", kind: "Deployment", type: "allow", ruleTree: { spec: { replicas: LT(5) } } }],
+}`
+	if err := ioutil.WriteFile(rulesetPath, []byte(ruleset), 0644); err != nil {
+		t.Fatalf("%v", err)
+	}
+	ruleSet, err = NewLoader(gatekeeperFunctions).ParseRuleset(rulesetPath)
+	if err != nil {
+		t.Fatalf("Error parsing %v: %v", rulesetPath, err)
+	}
+	spec, _ := ruleSet.Rules[0].RuleTree["spec"].(map[string]interface{})
+	if replicas, _ = spec["replicas"].(map[string]interface{}); ruleSet.Rules[0].Name != "local" || replicas["operation"] != "<" {
+		t.Errorf("Expected the local library to be imported and LT() to be the packaged function, got %v", ruleSet.Rules[0])
+	}
+}
+
+func TestParseRulesets(t *testing.T) {
+	gatekeeperFunctions, err := GatekeeperFunctions()
+	if err != nil {
+		t.Fatalf("Error getting gatekeeper functions: %v", err)
+	}
+
+	base := "test_files/verifier_test_rulesets/base.jsonnet"
+	overlay := "test_files/verifier_test_rulesets/overlay.jsonnet"
+	for _, paths := range [][]string{
+		{"test_files/verifier_test_rulesets"},
+		{"test_files/verifier_test_rulesets/*.jsonnet"},
+		{base, overlay, base + "*"},
+	} {
+		files, err := RulesetFiles(paths)
+		if err != nil || !reflect.DeepEqual(files, []string{base, overlay}) {
+			t.Errorf("Expected %v to expand to the base and overlay rulesets, got %v and %v", paths, files, err)
+		}
+	}
+	if _, err := RulesetFiles([]string{"test_files/missing-*.jsonnet"}); err == nil {
+		t.Errorf("Expected an error for a glob that matches no rulesets")
+	}
+
+	ruleSet, err := ParseRulesets([]string{base, overlay}, gatekeeperFunctions)
+	if err != nil {
+		t.Fatalf("Error parsing rulesets: %v", err)
+	}
+	if !reflect.DeepEqual(ruleSet.Ignore, []string{"channel.yaml", "secrets.yaml"}) {
+		t.Errorf("Expected ignore lists to be combined, got %v", ruleSet.Ignore)
+	}
+	if len(ruleSet.Rules) != 2 {
+		t.Fatalf("Expected the overlay to disable one rule and add one, got %v", ruleSet.Rules)
+	}
+	expected := []struct {
+		Name   string
+		Source string
+	}{
+		{"replica-limit", overlay},
+		{"namespace-name", overlay},
+	}
+	for i, rule := range ruleSet.Rules {
+		if rule.Name != expected[i].Name || rule.Source != expected[i].Source {
+			t.Errorf("Expected rule %v from %v, got %v from %v", expected[i].Name, expected[i].Source, rule.Name, rule.Source)
+		}
+	}
+	replicas := ruleSet.Rules[0].RuleTree["spec"].(map[string]interface{})["replicas"].(map[string]interface{})
+	if replicas["value"] != 10.0 {
+		t.Errorf("Expected the overlay to override replica-limit, got %v", replicas)
+	}
+
+	// Rules keep their source, and so do their violations
+	merged := MergeRuleSets(RuleSet{Rules: []Rule{{Name: "a", Source: base}}}, RuleSet{Rules: []Rule{{Name: "b", Source: overlay}}})
+	if len(merged.Rules) != 2 || merged.Rules[0].Source != base || merged.Rules[1].Source != overlay {
+		t.Errorf("Expected rules to be concatenated with their source, got %v", merged.Rules)
+	}
+}
+
+func TestVerifyReader(t *testing.T) {
+	ruleSet := RuleSet{
+		Rules: []Rule{
+			{
+				Name:  "replica-limit",
+				Regex: ".*",
+				Kind:  "Deployment",
+				Type:  "allow",
+				RuleTree: map[string]interface{}{
+					"spec": map[string]interface{}{
+						"replicas": map[string]interface{}{"gatekeeper": true, "operation": "<", "value": 10},
+					},
+				},
+			},
+		},
+	}
+	content := `
+kind: Deployment
+metadata:
+  name: small
+spec:
+  replicas: 3
+---
+kind: Deployment
+metadata:
+  name: large
+  annotations:
+    gatekeeper.wish.com/skip: replica-limit
+spec:
+  replicas: 30
+---
+kind: Deployment
+metadata:
+  name: larger
+spec:
+  replicas: 40
+`
+	compiled := compileRuleSet(t, ruleSet)
+	report, err := compiled.VerifyReader("service/deployment.yaml", strings.NewReader(content))
+	if err != nil {
+		t.Errorf("Error verifying reader: %v", err)
+		return
+	}
+	if len(report.Violations) != 2 || report.Suppressed() != 1 || len(report.Unsuppressed()) != 1 {
+		t.Errorf("Expected 1 unsuppressed and 1 suppressed violation, got %v", report.Violations)
+	}
+	if !report.Fails(SeverityError) {
+		t.Errorf("Expected report to fail on errors")
+	}
+
+	if _, err := compiled.VerifyReader("service/deployment.yaml", strings.NewReader("kind: [")); err == nil {
+		t.Errorf("Expected an error when verifying an invalid stream")
+	}
+}
+
+func TestKinds(t *testing.T) {
+	ruleSet := RuleSet{
+		References: []string{ReferenceSecrets},
+		Rules: []Rule{
+			{Regex: ".*", Kind: "Deployment", Type: "allow", RuleTree: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"serviceAccountName": map[string]interface{}{"gatekeeper": true, "operation": "ref", "kind": "ServiceAccount"},
+				},
+			}},
+			{Regex: ".*", Kind: "Service", Type: "allow", RuleTree: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"selector": map[string]interface{}{"gatekeeper": true, "operation": "selects", "kind": "StatefulSet"},
+				},
+			}},
+		},
+	}
+	compiled, _ := Compile(ruleSet)
+	expected := []string{"Deployment", "Secret", "Service", "ServiceAccount", "StatefulSet"}
+	if kinds := compiled.Kinds(); !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("Expected kinds %v, got %v", expected, kinds)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	ruleSet, compiled := compileTestRuleset(t, `{
+		rules: [
+			{
+				name: "team-label",
+				match: {
+					apiGroups: ["apps"],
+					kinds: ["Deployment", "StatefulSet"],
+					namespaceSelector: { matchLabels: { env: "prod" } },
+					labelSelector: { matchExpressions: [{ key: "tier", operator: "NotIn", values: ["batch"] }] },
+					path: "^prod/",
+				},
+				exclude: {
+					annotations: { "example.com/legacy": "" },
+				},
+				type: "allow",
+				ruleTree: {
+					metadata: {
+						labels: {
+							team: EXISTS(),
+						},
+					},
+				},
+			},
+		],
+	}`)
+
+	resource := func(apiVersion string, kind string, namespace string, name string, labels map[string]interface{}, annotations map[string]interface{}) map[string]interface{} {
+		metadata := map[string]interface{}{"name": name, "labels": labels, "annotations": annotations}
+		if namespace != "" {
+			metadata["namespace"] = namespace
+		}
+		return map[string]interface{}{"apiVersion": apiVersion, "kind": kind, "metadata": metadata}
+	}
+	report, err := compiled.VerifyInputs(Inputs{Resources: map[string][]map[string]interface{}{
+		"prod/namespaces.yaml": {
+			resource("v1", "Namespace", "", "prod", map[string]interface{}{"env": "prod"}, nil),
+			resource("v1", "Namespace", "", "dev", map[string]interface{}{"env": "dev"}, nil),
+		},
+		"prod/apps.yaml": {
+			resource("apps/v1", "Deployment", "prod", "web", nil, nil),
+			resource("apps/v1", "StatefulSet", "prod", "db", nil, nil),
+			resource("apps/v1", "Deployment", "prod", "batch", map[string]interface{}{"tier": "batch"}, nil),
+			resource("apps/v1", "Deployment", "prod", "legacy", nil, map[string]interface{}{"example.com/legacy": "true"}),
+			resource("extensions/v1beta1", "Deployment", "prod", "old", nil, nil),
+			resource("apps/v1", "Deployment", "dev", "web", nil, nil),
+			resource("v1", "Pod", "prod", "web", nil, nil),
+		},
+		"dev/apps.yaml": {
+			resource("apps/v1", "Deployment", "prod", "api", nil, nil),
+		},
+	}}, 1)
+	if err != nil {
+		t.Fatalf("Error verifying resources: %v", err)
+	}
+	names := []string{}
+	for _, v := range report.Violations {
+		names = append(names, v.Resource.Kind+"/"+v.Resource.Name)
+	}
+	if expected := []string{"Deployment/web", "StatefulSet/db"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected violations of %v, got %v", expected, report.Violations)
+	}
+
+	// Namespace selectors need the Namespace resources to be listed by audits
+	if kinds, expected := compiled.Kinds(), []string{"Deployment", "Namespace", "StatefulSet"}; !reflect.DeepEqual(kinds, expected) {
+		t.Errorf("Expected kinds %v, got %v", expected, kinds)
+	}
+
+	ruleSet.Rules[0].Match.LabelSelector.MatchExpressions[0].Operator = "Unknown"
+	ruleSet.Rules[0].Exclude.Path = "("
+	if _, errs := Compile(ruleSet); len(errs) != 2 {
+		t.Errorf("Expected an invalid label selector and path regex, got %v", errs)
+	}
+}
+
+func TestViolations(t *testing.T) {
+	testCases := []struct {
+		name     string
+		ruleset  string
+		path     string
+		content  string
+		expected []string
+	}{
+		{
+			name: "fixes",
+			ruleset: `{
+			rules: [
+				{
+					regex: ".*",
+					kind: "Deployment",
+					type: "allow",
+					ruleTree: {
+						metadata: {
+							namespace: PATH(1, fix=true),
+							labels: {
+								team: EQ("web", fix=true),
+								tier: DEFAULT("frontend"),
+								app: EQ("frontend"),
+							},
+						},
+					},
+				},
+			],
+		}`,
+			path: "web/deployment.yaml",
+			content: `
+kind: Deployment
+metadata:
+  name: frontend
+  namespace: default
+  labels:
+    team: api
+    app: backend
+`,
+			expected: []string{
+				"metadata.labels.app: Broken EQ() rule",
+				"metadata.labels.team: Broken EQ() rule (fix metadata.labels.team to web)",
+				"metadata.labels.tier: Broken DEFAULT() rule (fix metadata.labels.tier to frontend)",
+				"metadata.namespace: Broken PATH() rule (fix metadata.namespace to web)",
+			},
+		},
+		{
+			name: "coerce",
+			ruleset: `{
+			rules: [
+				{
+					regex: ".*",
+					kind: "Deployment",
+					type: "allow",
+					ruleTree: {
+						spec: {
+							replicas: LT(5, coerce=true),
+							minReadySeconds: GT(0),
+						},
+					},
+				},
+			],
+		}`,
+			path:    "web/deployment.json",
+			content: `{"kind": "Deployment", "metadata": {"name": "web"}, "spec": {"replicas": "30", "minReadySeconds": "10"}}`,
+			expected: []string{
+				"spec.minReadySeconds: Type mismatch in GT() rule",
+				"spec.replicas: Broken LT() rule",
+			},
+		},
+		{
+			name: "keys",
+			ruleset: `{
+			rules: [
+				{
+					regex: ".*",
+					kind: "Pod",
+					type: "allow",
+					ruleTree: {
+						spec: {
+							containers: EVERY({
+								ports: EVERY({ protocol: EQ("TCP", fix=true) }),
+							}),
+							volumes: INDEX(1, { name: EQ("data") }),
+						},
+					},
+				},
+			],
+		}`,
+			path: "web/pod.json",
+			content: `{"kind": "Pod", "metadata": {"name": "web"}, "spec": {
+			"containers": [{"ports": [{"protocol": "TCP"}]}, {"ports": [{"protocol": "TCP"}, {"protocol": "UDP"}]}],
+			"volumes": [{"name": "config"}, {"name": "cache"}]}}`,
+			expected: []string{
+				"spec.containers[1].ports[1].protocol: Broken EQ() rule (fix spec.containers[1].ports[1].protocol to TCP)",
+				"spec.volumes[1].name: Broken EQ() rule",
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		_, compiled := compileTestRuleset(t, testCase.ruleset)
+		report, err := compiled.VerifyReader(testCase.path, strings.NewReader(testCase.content))
+		if err != nil {
+			t.Errorf("Error verifying reader in test case %v: %v", testCase.name, err)
+			continue
+		}
+		// Violations are described by their key, message and fix
+		violations := []string{}
+		for _, v := range report.Violations {
+			violation := v.Key + ": " + v.Message
+			if v.Fix != nil {
+				violation += fmt.Sprintf(" (fix %v to %v)", v.Fix.Key, v.Fix.Value)
+			}
+			violations = append(violations, violation)
+		}
+		if !reflect.DeepEqual(violations, testCase.expected) {
+			t.Errorf("Expected \n%v\nbut got \n%v\nin test case %v", testCase.expected, violations, testCase.name)
+		}
+	}
 }
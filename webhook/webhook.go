@@ -0,0 +1,177 @@
+package webhook
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/wish/gatekeeper/verifier"
+)
+
+// maxRequestSize is the largest AdmissionReview body the handler will read
+const maxRequestSize = 10 << 20
+
+// Handler serves Kubernetes validating admission webhook requests
+type Handler struct {
+	RuleSet *verifier.CompiledRuleSet
+}
+
+// NewHandler creates a new webhook handler for the compiled ruleset
+func NewHandler(ruleSet *verifier.CompiledRuleSet) *Handler {
+	return &Handler{RuleSet: ruleSet}
+}
+
+// ServeHTTP decodes an AdmissionReview request and responds with the review result
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Only POST is allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, "Could not read request body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var review admissionv1.AdmissionReview
+	if err := json.Unmarshal(body, &review); err != nil {
+		http.Error(w, "Could not unmarshal AdmissionReview: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "AdmissionReview does not contain a request", http.StatusBadRequest)
+		return
+	}
+
+	review.Response = h.Review(review.Request)
+	review.Request = nil
+	review.APIVersion = admissionv1.SchemeGroupVersion.String()
+	review.Kind = "AdmissionReview"
+
+	b, err := json.Marshal(review)
+	if err != nil {
+		http.Error(w, "Could not marshal AdmissionReview: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// Review verifies the object of an admission request and returns whether it is allowed, with the warnings of
+// violations that are less severe than errors
+func (h *Handler) Review(req *admissionv1.AdmissionRequest) *admissionv1.AdmissionResponse {
+	resp := &admissionv1.AdmissionResponse{
+		UID:     req.UID,
+		Allowed: true,
+	}
+	if req.Operation == admissionv1.Delete || len(req.Object.Raw) == 0 {
+		return resp
+	}
+
+	var resource map[string]interface{}
+	if err := json.Unmarshal(req.Object.Raw, &resource); err != nil {
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusBadRequest,
+			Reason:  metav1.StatusReasonBadRequest,
+			Message: "Could not unmarshal object: " + err.Error(),
+		}
+		return resp
+	}
+
+	// Only errors deny the object, like they fail the command line, less severe violations are returned as warnings
+	report := verifier.NewReport(h.RuleSet.VerifyResource(ResourcePath(req.Namespace, resourceName(req, resource)), resource))
+	messages := []string{}
+	for _, v := range report.Unsuppressed() {
+		if v.Fails(verifier.SeverityError) {
+			messages = append(messages, v.Error())
+		} else {
+			resp.Warnings = append(resp.Warnings, strings.Join(strings.Fields(v.Error()), " "))
+		}
+	}
+	if len(messages) > 0 {
+		resp.Allowed = false
+		resp.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    http.StatusForbidden,
+			Reason:  metav1.StatusReasonForbidden,
+			Message: "Denied by gatekeeper: \n" + strings.Join(messages, "\n"),
+		}
+	}
+	return resp
+}
+
+// ResourcePath returns the virtual path used for regex and PATH() matching of an admitted resource,
+// so that PATH(0) is the name of the resource and PATH(1) is its namespace
+func ResourcePath(namespace string, name string) string {
+	if namespace == "" {
+		namespace = "default"
+	}
+	return namespace + "/" + name
+}
+
+// Returns the name of an admitted resource, CREATE requests of resources named by the API server do not have a
+// name yet, so the generateName prefix of the resource is used instead
+func resourceName(req *admissionv1.AdmissionRequest, resource map[string]interface{}) string {
+	if req.Name != "" {
+		return req.Name
+	}
+	if md, ok := resource["metadata"].(map[string]interface{}); ok {
+		if generateName, ok := md["generateName"].(string); ok {
+			return generateName
+		}
+	}
+	return ""
+}
+
+// SelfSignedCertificate generates a self-signed certificate for the given hosts, for local testing
+func SelfSignedCertificate(hosts []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Could not generate private key: %v", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Could not generate serial number: %v", err)
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"gatekeeper"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("Could not create certificate: %v", err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, nil
+}
@@ -0,0 +1,213 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/wish/gatekeeper/verifier"
+)
+
+var testRuleSet = verifier.RuleSet{
+	References: []string{verifier.ReferenceSecrets, verifier.ReferenceServiceAccounts},
+	Rules: []verifier.Rule{
+		{
+			Regex: ".*",
+			Kind:  "Deployment",
+			Type:  "allow",
+			RuleTree: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"replicas": map[string]interface{}{
+						"gatekeeper": true,
+						"operation":  "<",
+						"value":      10,
+					},
+				},
+			},
+		},
+		{
+			Name:  "service-account",
+			Regex: ".*",
+			Kind:  "Deployment",
+			Type:  "allow",
+			RuleTree: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"template": map[string]interface{}{
+						"spec": map[string]interface{}{
+							"serviceAccountName": map[string]interface{}{
+								"gatekeeper": true,
+								"operation":  "ref",
+								"kind":       "ServiceAccount",
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:     "cluster-ip",
+			Severity: verifier.SeverityWarning,
+			Regex:    ".*",
+			Kind:     "Service",
+			Type:     "allow",
+			RuleTree: map[string]interface{}{
+				"spec": map[string]interface{}{
+					"type": map[string]interface{}{
+						"gatekeeper": true,
+						"operation":  "=",
+						"value":      "ClusterIP",
+					},
+				},
+			},
+		},
+	},
+}
+
+// newTestServer starts the webhook over TLS with a self-signed certificate and returns a client trusting it
+func newTestServer(t *testing.T) (*httptest.Server, *http.Client) {
+	cert, err := SelfSignedCertificate([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("Could not generate self-signed certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Could not parse self-signed certificate: %v", err)
+	}
+
+	compiled, errs := verifier.Compile(testRuleSet)
+	if len(errs) > 0 {
+		t.Fatalf("Could not compile test ruleset: %v", errs)
+	}
+	server := httptest.NewUnstartedServer(NewHandler(compiled))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	return server, client
+}
+
+// review sends an AdmissionReview for the object to the webhook and returns the response
+func review(t *testing.T, server *httptest.Server, client *http.Client, object string) *admissionv1.AdmissionResponse {
+	request := admissionv1.AdmissionReview{
+		Request: &admissionv1.AdmissionRequest{
+			UID:       "test-uid",
+			Name:      "service",
+			Namespace: "service",
+			Operation: admissionv1.Create,
+			Object:    runtime.RawExtension{Raw: []byte(object)},
+		},
+	}
+	request.APIVersion = "admission.k8s.io/v1"
+	request.Kind = "AdmissionReview"
+	b, _ := json.Marshal(request)
+
+	resp, err := client.Post(server.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("Error posting AdmissionReview: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %v", resp.StatusCode)
+	}
+
+	var result admissionv1.AdmissionReview
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Error decoding AdmissionReview response: %v", err)
+	}
+	if result.Response == nil || result.Response.UID != "test-uid" {
+		t.Fatalf("Expected a response for uid test-uid, got %v", result.Response)
+	}
+	return result.Response
+}
+
+func TestServeAllowed(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+
+	resp := review(t, server, client, `{"kind": "Deployment", "metadata": {"name": "service"}, "spec": {"replicas": 3}}`)
+	if !resp.Allowed {
+		t.Errorf("Expected object to be allowed, got %v", resp.Result)
+	}
+}
+
+func TestServeDenied(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+
+	resp := review(t, server, client, `{"kind": "Deployment", "metadata": {"name": "service"}, "spec": {"replicas": 30}}`)
+	if resp.Allowed {
+		t.Errorf("Expected object to be denied")
+	} else if resp.Result == nil || !bytes.Contains([]byte(resp.Result.Message), []byte("Broken LT() rule")) {
+		t.Errorf("Expected denial message to contain the violation, got %v", resp.Result)
+	}
+}
+
+func TestServeWarning(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+
+	// Warnings do not deny objects by default, like they do not fail the command line
+	resp := review(t, server, client, `{"kind": "Service", "metadata": {"name": "service"}, "spec": {"type": "NodePort"}}`)
+	if !resp.Allowed {
+		t.Errorf("Expected object with a warning to be allowed, got %v", resp.Result)
+	}
+	if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "Broken EQ() rule") || strings.Contains(resp.Warnings[0], "\n") {
+		t.Errorf("Expected a single line warning of the violation, got %v", resp.Warnings)
+	}
+}
+
+func TestServeReferences(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+
+	// The referenced objects are in the cluster, not in the request, so references are not checked
+	resp := review(t, server, client, `{"kind": "Deployment", "metadata": {"name": "service"}, "spec": {"replicas": 3, "template": {"spec": {
+		"serviceAccountName": "service",
+		"volumes": [{"name": "tls", "secret": {"secretName": "service-tls"}}]}}}}`)
+	if !resp.Allowed {
+		t.Errorf("Expected object with references to be allowed, got %v", resp.Result)
+	}
+}
+
+func TestReviewGenerateName(t *testing.T) {
+	compiled, errs := verifier.Compile(verifier.RuleSet{Rules: []verifier.Rule{{Regex: "^worker-$", Kind: "Pod", Type: "deny"}}})
+	if len(errs) > 0 {
+		t.Fatalf("Could not compile ruleset: %v", errs)
+	}
+	req := &admissionv1.AdmissionRequest{
+		UID:       "test-uid",
+		Namespace: "jobs",
+		Operation: admissionv1.Create,
+		Object:    runtime.RawExtension{Raw: []byte(`{"kind": "Pod", "metadata": {"generateName": "worker-"}}`)},
+	}
+
+	// The path of an object named by the API server ends in its generateName prefix
+	resp := NewHandler(compiled).Review(req)
+	if resp.Allowed || resp.Result == nil || !strings.Contains(resp.Result.Message, "jobs/worker-") {
+		t.Errorf("Expected object to be denied at path jobs/worker-, got %v", resp.Result)
+	}
+}
+
+func TestServeBadRequest(t *testing.T) {
+	server, client := newTestServer(t)
+	defer server.Close()
+
+	resp, err := client.Post(server.URL, "application/json", bytes.NewReader([]byte("{}")))
+	if err != nil {
+		t.Fatalf("Error posting AdmissionReview: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a review without a request, got %v", resp.StatusCode)
+	}
+}